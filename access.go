@@ -0,0 +1,226 @@
+package go_chat_i_guess
+
+import (
+    crand "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "sort"
+    "sync"
+)
+
+// AccessMode defines which users may connect to a channel.
+type AccessMode uint
+
+const (
+    // Every user that isn't banned may connect to the channel.
+    AccessPublic AccessMode = iota
+    // Only invited users may connect to the channel.
+    AccessInviteOnly
+    // Only users that supplied the channel's passphrase when requesting
+    // their tokens, and invited users, may connect to the channel.
+    AccessPassphrase
+)
+
+func (m AccessMode) String() string {
+    switch m {
+    case AccessPublic:
+        return "public"
+    case AccessInviteOnly:
+        return "invite-only"
+    case AccessPassphrase:
+        return "passphrase"
+    default:
+        return "unknown"
+    }
+}
+
+// access controls which users may connect to a channel.
+type access struct {
+    // mode of the channel.
+    mode AccessMode
+
+    // invited lists the users that may connect to the channel regardless
+    // of its mode.
+    invited map[string]struct{}
+
+    // salt used to hash the passphrase.
+    salt []byte
+
+    // hash of the salted passphrase. If this is nil, no passphrase was
+    // set, and users may only connect to passphrase-protected channels if
+    // they were invited.
+    hash []byte
+
+    // grant is handed to the tokens issued with the channel's passphrase.
+    // It's regenerated whenever the passphrase changes, so tokens issued
+    // with a previous passphrase get rejected.
+    grant string
+
+    // lock synchronizes access to the fields.
+    lock sync.Mutex
+}
+
+// newAccess create the access control of a public channel.
+func newAccess() *access {
+    return &access {
+        invited: make(map[string]struct{}),
+    }
+}
+
+// hashPassphrase hash `passphrase` with the given `salt`.
+func hashPassphrase(salt []byte, passphrase string) []byte {
+    h := sha256.New()
+    h.Write(salt)
+    h.Write([]byte(passphrase))
+    return h.Sum(nil)
+}
+
+// AccessMode retrieve which users may connect to this channel.
+func (c *channel) AccessMode() AccessMode {
+    c.access.lock.Lock()
+    defer c.access.lock.Unlock()
+
+    return c.access.mode
+}
+
+// SetAccessMode change which users may connect to this channel. Users
+// already connected aren't affected.
+func (c *channel) SetAccessMode(mode AccessMode) {
+    c.access.lock.Lock()
+    c.access.mode = mode
+    c.access.lock.Unlock()
+
+    if c.debugLog && c.logger != nil {
+        c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Setting the access mode...\n\tchannel: \"%s\"\n\tmode: \"%s\"",
+                c.name, mode)
+    }
+}
+
+// SetPassphrase change the passphrase required to connect to this
+// channel, while it's in `AccessPassphrase` mode. Tokens issued with the
+// previous passphrase are rejected. If `passphrase` is empty, only
+// invited users may connect to the channel.
+func (c *channel) SetPassphrase(passphrase string) error {
+    if len(passphrase) == 0 {
+        c.access.lock.Lock()
+        c.access.salt = nil
+        c.access.hash = nil
+        c.access.grant = ""
+        c.access.lock.Unlock()
+        return nil
+    }
+
+    var salt [16]byte
+    var grant [16]byte
+
+    _, err := crand.Read(salt[:])
+    if err == nil {
+        _, err = crand.Read(grant[:])
+    }
+    if err != nil {
+        if c.logger != nil {
+            c.logger.Printf("[ERROR] go_chat_i_guess/channel: Failed to set the passphrase.\n\tchannel: \"%s\"\n\terror: %+v",
+                    c.name, err)
+        }
+        return err
+    }
+
+    c.access.lock.Lock()
+    c.access.salt = salt[:]
+    c.access.hash = hashPassphrase(salt[:], passphrase)
+    c.access.grant = hex.EncodeToString(grant[:])
+    c.access.lock.Unlock()
+
+    return nil
+}
+
+// Invite `username` to this channel, so they may connect to it regardless
+// of its access mode (as long as they aren't banned).
+func (c *channel) Invite(username string) {
+    c.access.lock.Lock()
+    c.access.invited[username] = struct{}{}
+    c.access.lock.Unlock()
+
+    if c.debugLog && c.logger != nil {
+        c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Inviting user...\n\tchannel: \"%s\"\n\tuser: \"%s\"",
+                c.name, username)
+    }
+}
+
+// Uninvite `username` from this channel. If the user is currently
+// connected, they aren't removed from the channel.
+func (c *channel) Uninvite(username string) {
+    c.access.lock.Lock()
+    delete(c.access.invited, username)
+    c.access.lock.Unlock()
+}
+
+// ListInvited retrieve the users invited to this channel, sorted by name.
+func (c *channel) ListInvited() []string {
+    c.access.lock.Lock()
+    list := make([]string, 0, len(c.access.invited))
+    for username := range c.access.invited {
+        list = append(list, username)
+    }
+    c.access.lock.Unlock()
+
+    sort.Strings(list)
+    return list
+}
+
+// privileged check whether `username` may connect to the channel
+// regardless of its access mode, because they were invited or because
+// they may manage the channel's access.
+func (c *channel) privileged(username string) bool {
+    c.access.lock.Lock()
+    _, ok := c.access.invited[username]
+    c.access.lock.Unlock()
+
+    return ok || c.HasPermission(username, PermAccess)
+}
+
+// admits check whether `username` may connect to the channel, if it's
+// invite-only.
+func (c *channel) admits(username string) bool {
+    return c.AccessMode() != AccessInviteOnly || c.privileged(username)
+}
+
+// grantAccess check the `passphrase` supplied by `username`, when
+// requesting a token to the channel, retrieving the grant that must be
+// sent along the token.
+//
+// Fails with `InvalidPassphrase` if the channel requires a passphrase and
+// `passphrase` doesn't match it.
+func (c *channel) grantAccess(username, passphrase string) (string, error) {
+    if c.AccessMode() != AccessPassphrase || c.privileged(username) {
+        return "", nil
+    }
+
+    c.access.lock.Lock()
+    defer c.access.lock.Unlock()
+
+    if c.access.hash == nil {
+        return "", InvalidPassphrase
+    }
+    hash := hashPassphrase(c.access.salt, passphrase)
+    if subtle.ConstantTimeCompare(hash, c.access.hash) != 1 {
+        return "", InvalidPassphrase
+    }
+
+    return c.access.grant, nil
+}
+
+// checkGrant check whether `username` may connect to the channel with a
+// token carrying `grant`, if it's passphrase-protected.
+func (c *channel) checkGrant(username, grant string) bool {
+    if c.AccessMode() != AccessPassphrase || c.privileged(username) {
+        return true
+    }
+
+    c.access.lock.Lock()
+    defer c.access.lock.Unlock()
+
+    return len(c.access.grant) > 0 &&
+            subtle.ConstantTimeCompare([]byte(grant), []byte(c.access.grant)) == 1
+}
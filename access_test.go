@@ -0,0 +1,117 @@
+package go_chat_i_guess
+
+import (
+    "testing"
+)
+
+// TestInviteOnly check whether only invited users may connect to an
+// invite-only channel.
+func TestInviteOnly(t *testing.T) {
+    s := NewServerConf(GetDefaultServerConf())
+    defer s.Close()
+
+    s.CreateChannelWithOwner("chan", "owner")
+    c, _ := s.GetChannel("chan")
+    c.SetAccessMode(AccessInviteOnly)
+
+    if !c.IsPrivate() {
+        t.Error("Invite-only channel isn't private")
+    }
+
+    connect := func(username string) error {
+        tk, err := s.RequestToken(username, "chan")
+        if err != nil {
+            t.Fatalf("Couldn't generate the token: %+v", err)
+        }
+        return s.Connect(tk, NewMockConn())
+    }
+
+    if err := connect("user"); err != UserNotAllowed {
+        t.Errorf("Invalid error for an uninvited user! Expected '%+v' but got '%+v'", UserNotAllowed, err)
+    }
+    if err := connect("owner"); err != nil {
+        t.Errorf("Owner couldn't connect to the channel: %+v", err)
+    }
+
+    c.Invite("user")
+    if want, got := []string { "user" }, c.ListInvited(); len(got) != 1 || want[0] != got[0] {
+        t.Errorf("Invalid invited users! Expected %+v but got %+v", want, got)
+    }
+    if err := connect("user"); err != nil {
+        t.Errorf("Invited user couldn't connect to the channel: %+v", err)
+    }
+
+    c.Ban("user", 0)
+    c.Invite("user")
+    if err := connect("user"); err != UserBanned {
+        t.Errorf("Invalid error for a banned user! Expected '%+v' but got '%+v'", UserBanned, err)
+    }
+}
+
+// TestPassphrase check whether users must supply the channel's passphrase
+// to connect to it, regardless of the token provider.
+func TestPassphrase(t *testing.T) {
+    const cn = "chan"
+
+    providers := map[string]TokenProvider {
+        "random": NewRandomTokenProvider(nil),
+        "hmac": NewHMACTokenProvider([]byte("secret")),
+    }
+    for name, provider := range providers {
+        conf := GetDefaultServerConf()
+        conf.Tokens = provider
+        s := NewServerConf(conf)
+
+        // Tokens requested before the channel existed can't be checked.
+        early, _ := s.RequestToken("early", cn)
+
+        s.CreateChannelWithOwner(cn, "owner")
+        c, _ := s.GetChannel(cn)
+        c.SetAccessMode(AccessPassphrase)
+
+        _, err := s.RequestTokenWithOptions("user", cn, TokenOptions {})
+        if err != InvalidPassphrase {
+            t.Errorf("[%s] Invalid error for a channel without a passphrase! Expected '%+v' but got '%+v'", name, InvalidPassphrase, err)
+        }
+
+        c.SetPassphrase("open sesame")
+        _, err = s.RequestTokenWithOptions("user", cn, TokenOptions {
+            Passphrase: "wrong",
+        })
+        if err != InvalidPassphrase {
+            t.Errorf("[%s] Invalid error for a wrong passphrase! Expected '%+v' but got '%+v'", name, InvalidPassphrase, err)
+        }
+
+        opts := TokenOptions {
+            Passphrase: "open sesame",
+            Uses: -1,
+        }
+        tk, err := s.RequestTokenWithOptions("user", cn, opts)
+        if err != nil {
+            t.Fatalf("[%s] Couldn't generate the token: %+v", name, err)
+        }
+        if err := s.Connect(tk, NewMockConn()); err != nil {
+            t.Errorf("[%s] Couldn't connect with the passphrase: %+v", name, err)
+        }
+        c.RemoveUser("user")
+
+        if err := s.Connect(early, NewMockConn()); err != UserNotAllowed {
+            t.Errorf("[%s] Invalid error for a token without the passphrase! Expected '%+v' but got '%+v'", name, UserNotAllowed, err)
+        }
+
+        // Changing the passphrase rejects tokens issued with the old one.
+        c.SetPassphrase("new")
+        if err := s.Connect(tk, NewMockConn()); err != UserNotAllowed {
+            t.Errorf("[%s] Invalid error for a token with an old passphrase! Expected '%+v' but got '%+v'", name, UserNotAllowed, err)
+        }
+
+        owner, err := s.RequestToken("owner", cn)
+        if err != nil {
+            t.Errorf("[%s] Owner couldn't request a token without the passphrase: %+v", name, err)
+        } else if err := s.Connect(owner, NewMockConn()); err != nil {
+            t.Errorf("[%s] Owner couldn't connect without the passphrase: %+v", name, err)
+        }
+
+        s.Close()
+    }
+}
@@ -0,0 +1,67 @@
+package go_chat_i_guess
+
+// ConnDescriptor describes the connection of a user that is connecting to
+// a channel.
+type ConnDescriptor struct {
+    // Conn used to communicate with the user.
+    Conn Conn
+
+    // RemoteAddr is the address of the user's remote endpoint, if `Conn`
+    // implements `RemoteAddr() string`. Otherwise, this is empty.
+    RemoteAddr string
+}
+
+// newConnDescriptor describe the connection `conn`.
+func newConnDescriptor(conn Conn) ConnDescriptor {
+    desc := ConnDescriptor {
+        Conn: conn,
+    }
+    if addr, ok := conn.(interface{ RemoteAddr() string }); ok {
+        desc.RemoteAddr = addr.RemoteAddr()
+    }
+
+    return desc
+}
+
+// Authorizer is a last-chance check on a user connecting to a channel,
+// done as soon as their token gets consumed.
+type Authorizer interface {
+    // Authorize check whether `username` may connect to `channel`, with a
+    // token granting `claims`, through the connection described by
+    // `conn`.
+    //
+    // Returning an error vetoes the connection, and the error is returned
+    // by `ChatServer.Connect`. `AuthError` may be used to report why the
+    // connection was rejected.
+    Authorize(username, channel string, claims TokenClaims,
+            conn ConnDescriptor) error
+}
+
+// AuthorizerFunc is a function that implements `Authorizer`.
+type AuthorizerFunc func(username, channel string, claims TokenClaims,
+        conn ConnDescriptor) error
+
+// Authorize call `f`.
+func (f AuthorizerFunc) Authorize(username, channel string,
+        claims TokenClaims, conn ConnDescriptor) error {
+    return f(username, channel, claims, conn)
+}
+
+// AuthError reports that an `Authorizer` vetoed a connection.
+type AuthError struct {
+    // Reason why the connection was vetoed.
+    Reason string
+}
+
+func (e *AuthError) Error() string {
+    if len(e.Reason) == 0 {
+        return Unauthorized.Error()
+    }
+    return Unauthorized.Error() + ": " + e.Reason
+}
+
+// Unwrap retrieve `Unauthorized`, so `errors.Is(err, Unauthorized)` works
+// for every `AuthError`.
+func (e *AuthError) Unwrap() error {
+    return Unauthorized
+}
@@ -0,0 +1,44 @@
+package go_chat_i_guess
+
+import (
+    "errors"
+    "strings"
+    "testing"
+)
+
+// TestAuthorizer check whether the authorizer may veto connections.
+func TestAuthorizer(t *testing.T) {
+    conf := GetDefaultServerConf()
+    conf.Authorizer = AuthorizerFunc(func(username, channel string,
+            claims TokenClaims, conn ConnDescriptor) error {
+        if conn.Conn == nil {
+            t.Error("Authorizer didn't receive the connection")
+        }
+        if claims.Options.Claims["state"] == "disabled" {
+            return &AuthError { Reason: "account disabled" }
+        }
+        return nil
+    })
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel("chan")
+
+    tk, _ := s.RequestToken("user", "chan")
+    if err := s.Connect(tk, NewMockConn()); err != nil {
+        t.Errorf("Authorized connection failed: %+v", err)
+    }
+
+    opts := TokenOptions {
+        Claims: map[string]string { "state": "disabled" },
+    }
+    tk, _ = s.RequestTokenWithOptions("other", "chan", opts)
+    err := s.Connect(tk, NewMockConn())
+    if err == nil {
+        t.Error("Vetoed connection succeeded")
+    } else if !errors.Is(err, Unauthorized) {
+        t.Errorf("Invalid error! Expected '%+v' but got '%+v'", Unauthorized, err)
+    } else if !strings.Contains(err.Error(), "account disabled") {
+        t.Errorf("Invalid error message: %s", err)
+    }
+}
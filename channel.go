@@ -1,9 +1,7 @@
 package go_chat_i_guess
 
 import (
-    "encoding/hex"
     "io"
-    "hash/crc32"
     "log"
     "time"
     "sync"
@@ -13,120 +11,139 @@ import (
 // For how long a given channel should be allowed
 const defIdleTimeout = time.Minute * 5
 
-// message represent a message received by the server, alongside its
-// metadata.
-type message struct {
-    // Date when the message was received by the server.
-    Date time.Time
-
-    // Message received by the server.
-    Message string
-
-    // From whom the message was sent. Empty for automatic/system messages.
-    From string
-
-    // To whom the message will be sent. Empty for broadcasts and
-    // omitted when encoded into JSON.
-    To string `json:-`
-}
-
-// Encode the message into a string that may be sent to users.
-func (m *message) Encode() string {
-    t := m.Date.Format("2006-01-02 - 15:04:05 (-0700)")
-    u := ""
-    if len(m.From) > 0 {
-        u = m.From + ": "
-    }
-    return t + " > " + u + m.Message
-}
-
-// getUID generate a unique identifier for the message.
-//
-// This should only be used for debugging purposes, as performance isn't
-// the primary concern of this function.
-func (m *message) getUID() string {
-    hasher := crc32.NewIEEE()
-
-    date, _ := m.Date.MarshalBinary()
-    hasher.Write(date)
-    hasher.Write([]byte(m.From))
-    hasher.Write([]byte(m.To))
-    hasher.Write([]byte(m.Message))
-
-    uid := hasher.Sum(nil)
-    return hex.EncodeToString(uid)
-}
-
-// MessageEncoder encodes a given message into the string that will be
-// sent by the channel.
-type MessageEncoder interface {
-    // Encode the message described in the parameter into the string that
-    // will be sent to the channel.
-    //
-    // Returning the empty string will cancel sending the message, which
-    // may be useful for command processing. It may also be used to reply
-    // directly, and exclusively, to the sender after processing the
-    // message.
-    //
-    // `from` is set internally by the `ChatChannel`, based on the `Conn`
-    // that received this message and forwarded it to the server. Using it
-    // to determine whether the requesting user is allowed to do some
-    // operation should be safe, as long as users are properly
-    // authenticated before generating their connection token.
-    Encode(channel ChatChannel, date time.Time, msg, from, to string) string
-}
-
-// ChannelController processes events received by the channel.
-type ChannelController interface {
-    MessageEncoder
-
+// ChannelEvents processes events received by the channel.
+type ChannelEvents interface {
     // OnConnect is called whenever a channel detects that a user has just
     // joined it.
     //
-    // If the channel doesn't have a `ChannelController`, it broadcasts the
-    // message:
+    // If the channel doesn't have a `ChannelEvents`, it broadcasts the
+    // `KindJoin` event:
     //
-    //     channel.NewSystemBroadcast(username + " entered " + channel.Name() + "...")
+    //     channel.NewSystemEvent(KindJoin, username + " entered " + channel.Name() + "...",
+    //             "", map[string]string { "user": username })
     OnConnect(channel ChatChannel, username string)
 
     // OnDisconnect is called whenever a channel detects that a user has
     // disconnected.
     //
-    // Since this may be called with some internal objects locked, only a
-    // few channel methods may be called from this function. For this
-    // reason, the `RestrictedChatChannel` SHALL NOT be cast and used as a
-    // `ChatChannel`!
+    // This is called from the channel's goroutine, after every message
+    // queued before the user left, and before the user may join again.
+    // Messages queued through `channel` don't wait for the channel's
+    // queue to have room, and are handled right after the event.
     //
-    // If the channel doesn't have a `ChannelController`, it broadcasts the
-    // message:
+    // If the channel doesn't have a `ChannelEvents`, it broadcasts the
+    // `KindLeave` event:
     //
-    //     channel.NewSystemBroadcast(username + " exited " + channel.Name() + "...")
+    //     channel.NewSystemEvent(KindLeave, username + " exited " + channel.Name() + "...",
+    //             "", map[string]string { "user": username })
     OnDisconnect(channel RestrictedChatChannel, username string)
 }
 
+// defaultEvents processes events for channels without a `ChannelEvents`.
+type defaultEvents struct {}
+
+// OnConnect broadcasts a `KindJoin` event.
+func (defaultEvents) OnConnect(channel ChatChannel, username string) {
+    channel.NewSystemEvent(KindJoin, username + " entered " + channel.Name() + "!",
+            "", map[string]string { "user": username })
+}
+
+// OnDisconnect broadcasts a `KindLeave` event.
+func (defaultEvents) OnDisconnect(channel RestrictedChatChannel,
+        username string) {
+    channel.NewSystemEvent(KindLeave, username + " exited " + channel.Name() + "...",
+            "", map[string]string { "user": username })
+}
+
+// ChannelController encodes messages and processes events received by
+// the channel.
+type ChannelController interface {
+    MessageEncoder
+    ChannelEvents
+}
+
 // A chat channel, to which users may connect to.
 type channel struct {
     // name of this channel.
     name string
 
     // encoder optionally encodes/process messages. If not defined,
-    // `message.Encode()` is used instead.
-    encoder MessageEncoder
+    // `Message.Encode()` is used instead.
+    encoder MessageEncoderV2
 
-    // controller optionally processes events. See `ChannelController` for
-    // the default behaviour, if not supplied.
-    controller ChannelController
+    // events processes events. See `ChannelEvents` for the default
+    // behaviour, if not supplied by the application.
+    events ChannelEvents
 
     // recv messages sent from a remote client.
-    recv chan *message
+    recv chan *Message
+
+    // backlog of tasks that must be run by the channel's goroutine, but
+    // that can't wait for `recv` to have room (for example, because they
+    // were queued by the goroutine itself).
+    backlog []func()
+
+    // lockBacklog synchronizes access to `backlog`.
+    lockBacklog sync.Mutex
+
+    // wake signals, without blocking, that `backlog` isn't empty.
+    wake chan struct{}
+
+    // history of the last messages broadcast by this channel. If this is
+    // nil, no history is kept.
+    history *history
+
+    // historyReplay is the number of messages from `history` sent to
+    // users as soon as they join the channel.
+    historyReplay int
 
-    // log every message received by this channel.
-    log []*message
+    // seq is the sequence number of the last broadcast. This must only be
+    // accessed from the channel's goroutine.
+    seq uint64
+
+    // store persists every broadcast. If this is nil, messages are only
+    // kept in `history`.
+    store MessageStore
 
     // idleTimeout after which this channel is automatically closed, if no
     // user connected to it.
     idleTimeout time.Duration
 
+    // sendQueueSize is the maximum number of messages queued for each
+    // user.
+    sendQueueSize int
+
+    // sendQueuePolicy is applied when a message is sent to a user whose
+    // queue is full.
+    sendQueuePolicy OverflowPolicy
+
+    // sessionPolicy is applied when a user connects to the channel more
+    // than once.
+    sessionPolicy SessionPolicy
+
+    // meta describes the channel.
+    meta metadata
+
+    // roles of the users in this channel.
+    roles *roles
+
+    // bans lists the users that may not connect to this channel.
+    bans *sanctions
+
+    // mutes lists the users whose broadcasts get dropped.
+    mutes *sanctions
+
+    // flood limits how fast users may send messages to this channel. If
+    // this is nil, messages aren't limited.
+    flood *flood
+
+    // validators check the messages sent by users before they get
+    // encoded.
+    validators []Validator
+
+    // access controls which users may connect to this channel.
+    access *access
+
     // Collection of users currently active in this chat room.
     users map[string]*user
 
@@ -151,15 +168,51 @@ type channel struct {
     debugLog bool
 }
 
-// newMessage queue a new message, setting its `Date` to the current time
-// and setting the other fields according to the arguments.
-func (c *channel) newMessage(msg, from, to string) {
-    packet := &message {
+// makeMessage create a new message of the given `kind`, setting its `Date`
+// to the current time and setting the other fields according to the
+// arguments.
+func (c *channel) makeMessage(kind MessageKind, msg, from, to string,
+        metadata map[string]string) *Message {
+
+    packet := &Message {
         Date: time.Now(),
         Message: msg,
         From: from,
         To: to,
+        Kind: kind,
+        Metadata: metadata,
+    }
+
+    id, err := newMessageID()
+    if err != nil {
+        // This should never happen, but the message's hash should be
+        // unique enough as a fallback.
+        if c.logger != nil {
+            c.logger.Printf("[ERROR] go_chat_i_guess/channel: Failed to generate a message ID.\n\tchannel: \"%s\"\n\terror: %+v",
+                    c.name, err)
+        }
+        id = packet.getUID()
     }
+    packet.ID = id
+
+    return packet
+}
+
+// newMessage queue a new message of the given `kind`, setting its `Date`
+// to the current time and setting the other fields according to the
+// arguments.
+func (c *channel) newMessage(kind MessageKind, msg, from, to string,
+        metadata map[string]string) {
+
+    c.queue(c.makeMessage(kind, msg, from, to, metadata))
+}
+
+// queue `packet` to be handled by the channel's goroutine, waiting for
+// the channel's queue to have room for it.
+//
+// This must never be called from the channel's goroutine, which would
+// wait for itself if the queue were full. See `deferredChannel`.
+func (c *channel) queue(packet *Message) {
     if c.debugLog && c.logger != nil {
         c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Sending message...\n\tchannel: \"%s\"\n\tdate: \"%+v\"\n\tfrom: \"%s\"\n\tto: \"%s\"\n\tmessage: \"%s\"\n\tuid: \"%s\"",
                 c.name, packet.Date, packet.From, packet.To,
@@ -173,21 +226,48 @@ func (c *channel) newMessage(msg, from, to string) {
 // setting its `Date` to the current time and setting the other fields
 // according to the arguments.
 func (c *channel) NewBroadcast(msg, from string) {
-    c.newMessage(msg, from, "")
+    c.newMessage(KindUser, msg, from, "", nil)
 }
 
 // NewSystemBroadcast queue a new system message (i.e., a message without
 // a sender), setting its `Date` to the current time and setting
 // `Message` to `msg`.
 func (c *channel) NewSystemBroadcast(msg string) {
-    c.newMessage(msg, "", "")
+    c.newMessage(KindSystem, msg, "", "", nil)
+}
+
+// NewWhisper queue a new private message from `from` to `to`, setting
+// its `Date` to the current time and setting `Message` to `msg`.
+//
+// The message is sent to both the receiver and the sender. If the
+// receiver isn't connected to the channel, the sender is notified
+// through a system whisper instead.
+func (c *channel) NewWhisper(msg, from, to string) {
+    c.newMessage(KindWhisper, msg, from, to, nil)
+}
+
+// newDirect queue a new direct message from `from` to `to`, who must be
+// connected to this channel. Differently from `NewWhisper`, the sender
+// doesn't receive a copy of the message, since they may not be in this
+// channel.
+func (c *channel) newDirect(msg, from, to string) {
+    c.newMessage(KindDirect, msg, from, to, nil)
 }
 
 // NewSystemWhisper queue a new system message (i.e., a message without a
 // sender) to a specific receiver, setting its `Date` to the current time
 // and setting `Message` to `msg`.
 func (c *channel) NewSystemWhisper(msg, to string) {
-    c.newMessage(msg, "", to)
+    c.newMessage(KindWhisper, msg, "", to, nil)
+}
+
+// NewSystemEvent queue a new system message (i.e., a message without a
+// sender) of the given `kind`, setting its `Date` to the current time and
+// setting the other fields according to the arguments. If `to` is empty,
+// the message is broadcast to every user.
+func (c *channel) NewSystemEvent(kind MessageKind, msg, to string,
+        metadata map[string]string) {
+    c.newMessage(kind, msg, "", to, metadata)
 }
 
 // Name retrieve the channel's name.
@@ -195,6 +275,30 @@ func (c *channel) Name() string {
     return c.name
 }
 
+// GetClaims retrieve a copy of the claims of `username`, as supplied when
+// they connected to this channel.
+//
+// Fails with `InvalidUser` if the user isn't connected to the channel.
+func (c *channel) GetClaims(username string) (map[string]string, error) {
+    c.lockUsers.Lock()
+    u, ok := c.users[username]
+    c.lockUsers.Unlock()
+
+    if !ok {
+        return nil, InvalidUser
+    }
+    return u.getClaims(), nil
+}
+
+// hasUser check whether `username` is connected to this channel.
+func (c *channel) hasUser(username string) bool {
+    c.lockUsers.Lock()
+    _, ok := c.users[username]
+    c.lockUsers.Unlock()
+
+    return ok
+}
+
 // GetUsers retrieve the list of connected users in this channel. If
 // `list` is supplied, the users are appended to the that list, so be
 // sure to empty it before calling this function.
@@ -208,6 +312,21 @@ func (c *channel) GetUsers(list []string) []string {
     return list
 }
 
+// GetHistory retrieve a copy of the, at most, `limit` newest messages
+// broadcast by this channel after `since`, from the oldest to the newest.
+//
+// If `since` is the zero time, every message in the history is
+// considered. Similarly, if `limit` isn't positive, every message is
+// returned. Only the last `ServerConf.HistorySize` messages are kept by
+// the channel.
+func (c *channel) GetHistory(since time.Time, limit int) []Message {
+    if c.history == nil {
+        return nil
+    }
+
+    return c.history.since(since, limit)
+}
+
 // IsClosed check if the channel is closed.
 //
 // The channel reports itself as being closed as soon as `c.Close()` was
@@ -222,11 +341,21 @@ func (c *channel) RemoveUserUnsafe(username string) {
     c.users[username].Close()
     delete(c.users, username)
 
-    if c.controller != nil {
-        c.controller.OnDisconnect(c, username)
-    } else {
-        c.NewSystemBroadcast(username + " exited " + c.name + "...")
-    }
+    c.onDisconnect(username)
+}
+
+// onDisconnect report that `username` has just left the channel.
+//
+// Reporting it may queue messages to this channel, but the caller may be
+// holding the users container, or it may even be the channel's
+// goroutine. So, instead of waiting for the channel's queue to have
+// room, the report is added to the channel's backlog. The backlog is run
+// before any message that gets queued later (like the user joining
+// again), so the report isn't reordered.
+func (c *channel) onDisconnect(username string) {
+    c.later(func() {
+        c.events.OnDisconnect(deferredChannel { c }, username)
+    })
 }
 
 // Remove the user `username` from this channel.
@@ -254,31 +383,97 @@ func (c *channel) RemoveUser(username string) error {
     return err
 }
 
-// messageUser send `msgStr` to the specified user `u`.
+// kickUser remove the user `username` from this channel, just like
+// `RemoveUser`, but sending them the system whisper `notice` before
+// closing their sessions.
+//
+// This must only be called from the channel's goroutine, which is the
+// only one that may encode messages.
+func (c *channel) kickUser(username, notice string) error {
+    // The notice is encoded beforehand, as the encoder may need to access
+    // the users container.
+    msg := c.makeMessage(KindWhisper, notice, "", username, nil)
+    msgStr := c.encode(msg)
+
+    c.lockUsers.Lock()
+    u, ok := c.users[username]
+    if ok {
+        u.kick(msgStr)
+        delete(c.users, username)
+    }
+    c.lockUsers.Unlock()
+
+    if !ok {
+        return InvalidUser
+    }
+
+    c.onDisconnect(username)
+    return nil
+}
+
+// whisperNow send the system whisper `msg` to `to` immediately, instead
+// of queueing it.
+//
+// This must only be called from the channel's goroutine, which can't
+// wait for its own queue to have room for the whisper.
+func (c *channel) whisperNow(msg, to string) {
+    c.handleMessage(c.makeMessage(KindWhisper, msg, "", to, nil))
+}
+
+// logSendError report that the channel failed to send a message to
+// `username`.
+func (c *channel) logSendError(username string, err error) {
+    if err == ConnEOF {
+        if c.debugLog && c.logger != nil {
+            c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Connection to user was closed.\n\tchannel: \"%s\"\n\tusername: \"%s\"",
+                    c.name, username)
+        }
+    } else if err != nil {
+        if c.logger != nil {
+            c.logger.Printf("[ERROR] go_chat_i_guess/channel: Couldn't send a message to the user.\n\tchannel: \"%s\"\n\tusername: \"%s\"\n\terror: %+v",
+                    c.name, username, err)
+        }
+    }
+}
+
+// messageUser queue `msgStr` to be sent to the specified user `u`.
 //
-// If the channel fails to send the message to the user, the user gets
-// removed from the channel. Therefore, the users container must have
-// been properly synchronized before calling this.
+// If the channel fails to queue the message to the user (because the
+// user is closed or because they can't keep up with the channel), the
+// user gets removed from the channel. Therefore, the users container must
+// have been properly synchronized before calling this.
 func (c *channel) messageUserUsafe(u *user, msgStr string) {
     err := u.SendStr(msgStr)
     if err != nil {
         username := u.GetName()
-        if err == ConnEOF {
-            if c.debugLog && c.logger != nil {
-                c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Connection to user was closed.\n\tchannel: \"%s\"\n\tusername: \"%s\"",
-                        c.name, username)
-            }
-        } else if err != nil {
-            if c.logger != nil {
-                c.logger.Printf("[ERROR] go_chat_i_guess/channel: Couldn't send a message to the user.\n\tchannel: \"%s\"\n\tusername: \"%s\"\n\terror: %+v",
-                        c.name, username, err)
-            }
-        }
-
+        c.logSendError(username, err)
         c.RemoveUserUnsafe(username)
     }
 }
 
+// dropSession close the session `s` after its connection failed with
+// `err`, removing its user from this channel if it was their last
+// session.
+//
+// Differently from `RemoveUser`, this doesn't remove another user that
+// may have since connected with the same name.
+func (c *channel) dropSession(s *session, err error) {
+    u := s.user
+    c.logSendError(u.name, err)
+
+    c.lockUsers.Lock()
+    found := u.removeSession(s) && c.users[u.name] == u
+    if found {
+        delete(c.users, u.name)
+    }
+    c.lockUsers.Unlock()
+
+    s.Close()
+    if found {
+        c.onDisconnect(u.name)
+    }
+}
+
 // run the channel, broadcasting every message received to every other user.
 //
 // When `newChannel()` is called, `c.run()` is executed in a new goroutine.
@@ -296,8 +491,13 @@ func (c *channel) run() {
             return
         case <-c.idle.C:
             c.checkConnections()
+        case <-c.wake:
+            c.runBacklog()
         case msg := <-c.recv:
+            // Tasks queued before the message must be run before it.
+            c.runBacklog()
             c.handleMessage(msg)
+            c.runBacklog()
         }
 
         // Reset the idle timeout on any active on the channel. If this
@@ -307,9 +507,60 @@ func (c *channel) run() {
     }
 }
 
+// later queue `task` to be run by the channel's goroutine, after the
+// message currently being handled (if any). Differently from `recv`,
+// this never blocks.
+func (c *channel) later(task func()) {
+    c.lockBacklog.Lock()
+    c.backlog = append(c.backlog, task)
+    c.lockBacklog.Unlock()
+
+    select {
+    case c.wake <- struct{}{}:
+    default:
+    }
+}
+
+// runBacklog run every task in the backlog, including the tasks queued
+// while running it, in the order they were queued.
+//
+// This must only be called from the channel's goroutine.
+func (c *channel) runBacklog() {
+    for {
+        c.lockBacklog.Lock()
+        list := c.backlog
+        c.backlog = nil
+        c.lockBacklog.Unlock()
+
+        if len(list) == 0 {
+            return
+        }
+        for _, task := range list {
+            task()
+        }
+    }
+}
+
+// encode the message using the application supplied encoder or, if the
+// channel doesn't have an encoder, using `Message.Encode()`.
+//
+// The encoder runs on the channel's goroutine, so it receives a
+// `deferredChannel` instead of the channel itself.
+func (c *channel) encode(msg *Message) string {
+    var msgStr string
+    if c.encoder == nil {
+        msgStr = msg.Encode()
+    } else {
+        msgStr = c.encoder.EncodeMessage(deferredChannel { c }, msg)
+    }
+
+    msg.encoded = true
+    return msgStr
+}
+
 // handleMessage encode the received message and broadcast it to every
 // connected user.
-func (c *channel) handleMessage(msg *message) {
+func (c *channel) handleMessage(msg *Message) {
     // uid is only used for debug printing.
     var uid string
 
@@ -320,37 +571,156 @@ func (c *channel) handleMessage(msg *message) {
                 c.name, msg.Date, msg.From, msg.To, msg.Message, uid)
     }
 
-    if len(msg.To) == 0 {
-        c.log = append(c.log, msg)
+    // Whispers and direct messages sent by users, and their edits, are
+    // subject to the same restrictions as their broadcasts.
+    userWhisper := msg.Kind == KindWhisper && len(msg.From) > 0
+    userDirect := msg.Kind == KindDirect && len(msg.From) > 0
+    restricted := msg.Kind == KindUser || msg.Kind == KindEdit ||
+            msg.Kind == KindReaction || userWhisper || userDirect
+
+    // Edits, deletions, reactions and thread updates only change another
+    // message, so they aren't logged by the channel.
+    logged := len(msg.To) == 0 && !msg.updates()
+
+    if restricted && c.mutes.has(msg.From) {
+        if c.debugLog && c.logger != nil {
+            c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Dropping message from muted user!\n\tuid: \"%s\"",
+                    uid)
+        }
+
+        c.whisperNow("You are muted in " + c.name + ".", msg.From)
+        return
     }
 
-    var msgStr string
-    if c.encoder == nil {
-        msgStr = msg.Encode()
-    } else {
-        // Encode the received message using the application supplied
-        // encoder. The application may cancel forwarding this message,
-        // by returning the empty string.
-        msgStr = c.encoder.Encode(c, msg.Date, msg.Message, msg.From,
-                msg.To)
-        if len(msgStr) == 0 {
+    if restricted && c.flood != nil && !c.limitFlood(msg) {
+        if c.debugLog && c.logger != nil {
+            c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Dropping message over the rate limit!\n\tuid: \"%s\"",
+                    uid)
+        }
+
+        return
+    }
+
+    // Reactions don't have any text of their own. Text derived from the
+    // message by the encoder (for example, by a command) must be validated
+    // by the encoder itself, through `Validate()`.
+    if restricted && msg.Kind != KindReaction {
+        err := c.validate(msg)
+        if err != nil {
+            if c.debugLog && c.logger != nil {
+                c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Dropping invalid message!\n\tuid: \"%s\"\n\terror: %+v",
+                        uid, err)
+            }
+
+            c.refuse(msg, err)
+            return
+        }
+    }
+
+    if msg.Kind == KindEdit || msg.Kind == KindDelete {
+        err := c.revise(msg)
+        if err != nil {
             if c.debugLog && c.logger != nil {
-                c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Message was filtered out!\n\tuid: \"%s\"",
-                        uid)
+                c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Couldn't revise the message!\n\tuid: \"%s\"\n\terror: %+v",
+                        uid, err)
             }
 
+            if len(msg.From) > 0 {
+                c.whisperNow("Couldn't change the message in " + c.name + ": " + err.Error() + ".",
+                        msg.From)
+            }
+            return
+        }
+    }
+
+    if msg.Kind == KindReaction {
+        changed, err := c.react(msg)
+        if err != nil {
+            if c.debugLog && c.logger != nil {
+                c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Couldn't react to the message!\n\tuid: \"%s\"\n\terror: %+v",
+                        uid, err)
+            }
+
+            c.whisperNow("Couldn't react to the message in " + c.name + ": " + err.Error() + ".",
+                    msg.From)
+            return
+        } else if !changed {
             return
         }
     }
 
+    if logged && msg.Kind != KindWhisper {
+        c.seq++
+        msg.Seq = c.seq
+    } else if !msg.updates() && !c.hasUser(msg.To) {
+        if c.debugLog && c.logger != nil {
+            c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Dropping message to missing user!\n\tuid: \"%s\"",
+                    uid)
+        }
+
+        if userWhisper {
+            c.whisperNow(msg.To + " isn't connected to " + c.name + ".",
+                    msg.From)
+        }
+        return
+    }
+
+    // The application may cancel forwarding this message, by encoding it
+    // as the empty string.
+    msgStr := c.encode(msg)
+    if len(msgStr) == 0 {
+        if c.debugLog && c.logger != nil {
+            c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Message was filtered out!\n\tuid: \"%s\"",
+                    uid)
+        }
+
+        return
+    }
+
+    // Permissions are only checked after encoding the message, so users
+    // that can't send messages may still send commands to the encoder.
+    if msg.Kind == KindUser && !c.HasPermission(msg.From, PermSend) {
+        if c.debugLog && c.logger != nil {
+            c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Dropping message from user without permission!\n\tuid: \"%s\"",
+                    uid)
+        }
+
+        c.whisperNow("You aren't allowed to send messages in " + c.name + ".",
+                msg.From)
+        return
+    } else if userWhisper && !c.HasPermission(msg.From, PermWhisper) {
+        if c.debugLog && c.logger != nil {
+            c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Dropping whisper from user without permission!\n\tuid: \"%s\"",
+                    uid)
+        }
+
+        c.whisperNow("You aren't allowed to whisper in " + c.name + ".",
+                msg.From)
+        return
+    }
+
     // Broadcast the message to every user. Alternatively, if the
     // message was directed to a specific user, send them the message
-    // and skip everything else.
+    // (and a copy to its sender, if any) and skip everything else.
     c.lockUsers.Lock()
 
+    // Broadcasts are logged while the users container is locked, so a
+    // joining user either receives it live or replayed from the history.
+    if logged && c.history != nil {
+        c.history.push(msg, msgStr)
+    }
+
     if len(msg.To) > 0 {
-        u := c.users[msg.To]
-        c.messageUserUsafe(u, msgStr)
+        // The receiver may have left while the message was being encoded.
+        if u, ok := c.users[msg.To]; ok {
+            c.messageUserUsafe(u, msgStr)
+        }
+        // Direct messages may come from other channels, so only whispers
+        // get copied back to their senders.
+        u, ok := c.users[msg.From]
+        if ok && msg.Kind == KindWhisper && msg.From != msg.To {
+            c.messageUserUsafe(u, msgStr)
+        }
     } else {
         for k := range c.users {
             u := c.users[k]
@@ -359,6 +729,22 @@ func (c *channel) handleMessage(msg *message) {
     }
 
     c.lockUsers.Unlock()
+
+    c.touch(msg.Date)
+
+    if logged && c.store != nil {
+        err := c.store.Append(c.name, *msg)
+        if err != nil && c.logger != nil {
+            c.logger.Printf("[ERROR] go_chat_i_guess/channel: Couldn't store the message.\n\tchannel: \"%s\"\n\tseq: %d\n\terror: %+v",
+                    c.name, msg.Seq, err)
+        }
+    }
+
+    if logged && len(msg.ReplyTo) > 0 {
+        if parent, ok := c.addReply(msg, true); ok {
+            c.handleMessage(c.threadEvent(&parent))
+        }
+    }
 }
 
 // checkConnections send a dummy message to every connect user to check if
@@ -369,6 +755,10 @@ func (c *channel) checkConnections() {
                 c.name)
     }
 
+    if c.flood != nil {
+        c.flood.prune(time.Now())
+    }
+
     c.lockUsers.Lock()
     for k := range c.users {
         u := c.users[k]
@@ -387,6 +777,134 @@ func (c *channel) checkConnections() {
     c.lockUsers.Unlock()
 }
 
+// ConnectOptions customizes how a user joins a channel.
+type ConnectOptions struct {
+    // Resume the user's previous connection, replaying every message in
+    // the channel's history after `ResumeAfter` instead of the last
+    // `ServerConf.HistoryReplay` messages.
+    //
+    // Only messages still kept in the channel's history may be replayed.
+    Resume bool
+
+    // ResumeAfter is the sequence number of the last message received by
+    // the user, if `Resume` is set.
+    ResumeAfter uint64
+
+    // Claims are arbitrary values associated with the user while they are
+    // connected (for example, their display name), as retrieved by
+    // `ChatChannel.GetClaims`.
+    Claims map[string]string `json:",omitempty"`
+}
+
+// addSession connect `username` to the channel through `conn`, replaying
+// the channel's history to the new session before any other message.
+//
+// If the user is already connected to the channel, the channel's
+// `SessionPolicy` decides whether the connection is rejected, added to
+// the user's sessions or replaces the user's sessions. The second value
+// reports whether the user just joined the channel.
+//
+// The replay happens while the users container is locked, so no
+// broadcast may be either lost or sent twice to the new session.
+func (c *channel) addSession(username string, conn Conn,
+        opts ConnectOptions) (*session, bool, error) {
+
+    if c.bans.has(username) {
+        if c.logger != nil {
+            c.logger.Printf("[ERROR] go_chat_i_guess/channel: Banned user tried to connect to the channel.\n\tchannel: \"%s\"\n\tuser: \"%s\"",
+                    c.name, username)
+        }
+        return nil, false, UserBanned
+    } else if !c.admits(username) {
+        if c.logger != nil {
+            c.logger.Printf("[ERROR] go_chat_i_guess/channel: User not allowed tried to connect to the channel.\n\tchannel: \"%s\"\n\tuser: \"%s\"",
+                    c.name, username)
+        }
+        return nil, false, UserNotAllowed
+    }
+
+    c.lockUsers.Lock()
+    defer c.lockUsers.Unlock()
+
+    u, ok := c.users[username]
+    if ok && c.sessionPolicy == SessionTakeover {
+        if c.debugLog && c.logger != nil {
+            c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Taking over the user's sessions...\n\tchannel: \"%s\"\n\tuser: \"%s\"",
+                    c.name, username)
+        }
+
+        // The previous sessions are replaced without going through
+        // `RemoveUserUnsafe`, so the user doesn't leave the channel. They
+        // are only notified and closed by the channel's goroutine, which
+        // is the only one that may encode messages.
+        list := u.takeSessions()
+        c.later(func() {
+            c.kickSessions(username, list)
+        })
+    } else if ok && c.sessionPolicy != SessionMulti {
+        if c.logger != nil {
+            c.logger.Printf("[ERROR] go_chat_i_guess/channel: User tried to connect more than once to a channel.\n\tchannel: \"%s\"\n\tuser: \"%s\"",
+                    c.name, username)
+        }
+        return nil, false, UserAlreadyConnected
+    } else if !ok {
+        u = newUser(username, c, c.logger, c.debugLog)
+    } else if c.debugLog && c.logger != nil {
+        c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Opening another session for the user...\n\tchannel: \"%s\"\n\tuser: \"%s\"",
+                c.name, username)
+    }
+
+    var replay []string
+    if c.history != nil && opts.Resume {
+        replay = c.history.after(opts.ResumeAfter)
+    } else if c.history != nil && c.historyReplay > 0 {
+        replay = c.history.last(c.historyReplay)
+    }
+
+    if c.debugLog && c.logger != nil && len(replay) > 0 {
+        c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Replaying the channel's history...\n\tchannel: \"%s\"\n\tuser: \"%s\"\n\tmessages: %d",
+                c.name, username, len(replay))
+    }
+
+    s := u.addSession(conn)
+    for _, msgStr := range replay {
+        err := s.replay(msgStr)
+        if err != nil {
+            if c.logger != nil {
+                c.logger.Printf("[ERROR] go_chat_i_guess/channel: Couldn't replay the channel's history to the user.\n\tchannel: \"%s\"\n\tuser: \"%s\"\n\terror: %+v",
+                        c.name, username, err)
+            }
+            u.removeSession(s)
+            s.discard()
+            return nil, false, err
+        }
+    }
+
+    u.setClaims(opts.Claims)
+    c.users[username] = u
+    c.touch(time.Now())
+    return s, !ok, nil
+}
+
+// kickSessions close the sessions in `list`, which were replaced by a new
+// session of `username`, after notifying them.
+//
+// This must only be called from the channel's goroutine.
+func (c *channel) kickSessions(username string, list []*session) {
+    msg := c.makeMessage(KindWhisper, "You connected to " + c.name + " from another session.",
+            "", username, nil)
+    notice := c.encode(msg)
+
+    for _, s := range list {
+        s.kick(notice)
+    }
+}
+
+// onConnect report that `username` has just joined the channel.
+func (c *channel) onConnect(username string) {
+    c.events.OnConnect(c, username)
+}
+
 // ConnectUser add a new user to the channel.
 //
 // It's entirely up to the caller to initialize the connection used by
@@ -398,35 +916,31 @@ func (c *channel) checkConnections() {
 //
 // If `conn` is nil, then this function will panic!
 func (c *channel) ConnectUser(username string, conn Conn) error {
-    var err error
+    return c.ConnectUserWithOptions(username, conn, ConnectOptions {})
+}
+
+// ConnectUserWithOptions add a new user to the channel, as customized by
+// `opts`.
+//
+// See `ConnectUser` for a more complete description.
+func (c *channel) ConnectUserWithOptions(username string, conn Conn,
+        opts ConnectOptions) error {
 
     if conn == nil {
         panic("go_chat_i_guess/channel ConnectUser: nil conn")
     }
 
-    u := newUserBg(username, c, conn, c.logger, c.debugLog)
-
-    c.lockUsers.Lock()
-    if _, ok := c.users[username]; ok {
-        if c.logger != nil {
-            c.logger.Printf("[ERROR] go_chat_i_guess/channel: User tried to connect more than once to a channel.\n\tchannel: \"%s\"\n\tuser: \"%s\"",
-                    c.name, username)
-        }
-        err = UserAlreadyConnected
-    } else {
-        c.users[username] = u
+    s, joined, err := c.addSession(username, conn, opts)
+    if err != nil {
+        return err
     }
-    c.lockUsers.Unlock()
 
-    if err == nil {
-        if c.controller != nil {
-            c.controller.OnConnect(c, username)
-        } else {
-            c.NewSystemBroadcast(username + " entered " + c.name +"!")
-        }
+    s.RunBg()
+    if joined {
+        c.onConnect(username)
     }
 
-    return err
+    return nil
 }
 
 // ConnectUser add a new user to the channel and blocks until the
@@ -444,31 +958,30 @@ func (c *channel) ConnectUser(username string, conn Conn) error {
 //
 // If `conn` is nil, then this function will panic!
 func (c *channel) ConnectUserAndWait(username string, conn Conn) error {
+    return c.ConnectUserWithOptionsAndWait(username, conn, ConnectOptions {})
+}
+
+// ConnectUserWithOptionsAndWait add a new user to the channel, as
+// customized by `opts`, and blocks until the user closes the connection
+// to the server.
+//
+// See `ConnectUserAndWait` for a more complete description.
+func (c *channel) ConnectUserWithOptionsAndWait(username string, conn Conn,
+        opts ConnectOptions) error {
+
     if conn == nil {
         panic("go_chat_i_guess/channel ConnectUserAndWait: nil conn")
     }
 
-    u := newUser(username, c, conn, c.logger, c.debugLog)
-
-    c.lockUsers.Lock()
-    if _, ok := c.users[username]; ok {
-        c.lockUsers.Unlock()
-
-        if c.logger != nil {
-            c.logger.Printf("[ERROR] go_chat_i_guess/channel: User tried to connect more than once to a channel.\n\tchannel: \"%s\"\n\tuser: \"%s\"",
-                    c.name, username)
-        }
-        return UserAlreadyConnected
+    s, joined, err := c.addSession(username, conn, opts)
+    if err != nil {
+        return err
     }
-    c.users[username] = u
-    c.lockUsers.Unlock()
 
-    if c.controller != nil {
-        c.controller.OnConnect(c, username)
-    } else {
-        c.NewSystemBroadcast(username + " entered " + c.name +"!")
+    if joined {
+        c.onConnect(username)
     }
-    u.RunAndWait()
+    s.RunAndWait()
 
     return nil
 }
@@ -511,11 +1024,26 @@ type RestrictedChatChannel interface {
     // setting `Message` to `msg`.
     NewSystemBroadcast(msg string)
 
+    // NewWhisper queue a new private message from `from` to `to`, setting
+    // its `Date` to the current time and setting `Message` to `msg`. The
+    // message is sent to both the receiver and the sender.
+    NewWhisper(msg, from, to string)
+
     // NewSystemWhisper queue a new system message (i.e., a message without
     // a sender) to a specific receiver, setting its `Date` to the current
     // time and setting `Message` to `msg`.
     NewSystemWhisper(msg, to string)
 
+    // NewSystemEvent queue a new system message (i.e., a message without
+    // a sender) of the given `kind`, setting its `Date` to the current
+    // time and setting the other fields according to the arguments. If
+    // `to` is empty, the message is broadcast to every user.
+    //
+    // This may be used to send events that should be encoded differently
+    // from regular messages, like `KindJoin` and `KindLeave`.
+    NewSystemEvent(kind MessageKind, msg, to string,
+            metadata map[string]string)
+
     // IsClosed check if the channel is closed.
     IsClosed() bool
 }
@@ -531,9 +1059,213 @@ type ChatChannel interface {
     // sure to empty it before calling this function.
     GetUsers(list []string) []string
 
+    // EditMessage replace the text of the message identified by `id`
+    // with `text`, updating the channel's history (and its store, if it
+    // implements `MessageUpdater`) and broadcasting a `KindEdit` event.
+    //
+    // Only messages still kept in the channel's history may be edited,
+    // and only by the user that sent them.
+    EditMessage(id, from, text string) error
+
+    // DeleteMessage delete the message identified by `id`, updating the
+    // channel's history (and its store, if it implements
+    // `MessageUpdater`) and broadcasting a `KindDelete` event.
+    //
+    // Only messages still kept in the channel's history may be deleted,
+    // either by the user that sent them or by a user allowed to use
+    // `PermDelete`.
+    DeleteMessage(id, by string) error
+
+    // AddReaction react to the message identified by `id` with `emoji`,
+    // updating the channel's history (and its store, if it implements
+    // `MessageUpdater`) and broadcasting a `KindReaction` event with
+    // every reaction to the message.
+    //
+    // Only messages still kept in the channel's history may be reacted
+    // to, and only by users allowed to use `PermSend`.
+    AddReaction(id, username, emoji string) error
+
+    // RemoveReaction remove the reaction `emoji` of `username` from the
+    // message identified by `id`, broadcasting a `KindReaction` event
+    // with every reaction left on the message.
+    RemoveReaction(id, username, emoji string) error
+
+    // GetReactions retrieve the reactions to the message identified by
+    // `id`, mapping each emoji to the users that reacted with it.
+    GetReactions(id string) (map[string][]string, error)
+
+    // NewReply queue a new broadcast message from `from`, replying to the
+    // message identified by `replyTo`. Once the reply is broadcast, the
+    // number of replies to that message is updated and broadcast as a
+    // `KindThread` event.
+    //
+    // Only messages still kept in the channel's history may be replied
+    // to. Replying to a reply sends the message to the same thread.
+    NewReply(msg, from, replyTo string) error
+
+    // GetThread retrieve a copy of the message identified by `parentID`,
+    // followed by every reply to it still kept in the channel's history,
+    // from the oldest to the newest.
+    GetThread(parentID string) ([]Message, error)
+
     // Remove the user `username` from this channel.
     RemoveUser(username string) error
 
+    // Topic retrieve the topic currently being discussed in this channel.
+    Topic() string
+
+    // SetTopic change the topic of this channel to `topic`, broadcasting
+    // the change to every user as a `KindTopic` event. `by` is the user
+    // that changed the topic, and may be empty if it was changed by the
+    // application.
+    SetTopic(topic, by string)
+
+    // Description retrieve the description of this channel.
+    Description() string
+
+    // SetDescription change the description of this channel.
+    SetDescription(description string)
+
+    // Creator retrieve the user that created this channel (i.e., its
+    // owner when it was created). This is empty if the channel was
+    // created by the application.
+    Creator() string
+
+    // Created retrieve when this channel was created.
+    Created() time.Time
+
+    // GetAttribute retrieve the free-form attribute `key` of this
+    // channel, and whether it was set.
+    GetAttribute(key string) (string, bool)
+
+    // SetAttribute set the free-form attribute `key` of this channel to
+    // `value`.
+    SetAttribute(key, value string)
+
+    // RemoveAttribute remove the attribute `key` from this channel.
+    RemoveAttribute(key string)
+
+    // Info retrieve a snapshot of this channel's metadata.
+    Info() ChannelInfo
+
+    // LastActivity retrieve when the last message was broadcast in this
+    // channel, or when the last user connected to it.
+    LastActivity() time.Time
+
+    // IsPrivate check whether only some users may connect to this
+    // channel, i.e., whether it isn't `AccessPublic`.
+    IsPrivate() bool
+
+    // Summary retrieve a snapshot of this channel, as listed by
+    // `ChatServer.ListChannels`.
+    Summary() ChannelSummary
+
+    // GetRole retrieve the role of `username` in this channel. Users
+    // without an explicitly assigned role are `RoleMember`s.
+    GetRole(username string) Role
+
+    // SetRole set the role of `username` in this channel.
+    SetRole(username string, role Role)
+
+    // SetPermission set the lowest role allowed to use `perm` in this
+    // channel, overriding `ServerConf.Permissions`.
+    SetPermission(perm Permission, role Role)
+
+    // Validate run `text` through the channel's validators (see
+    // `ServerConf.Validators`), retrieving the text that should be used
+    // instead.
+    //
+    // Messages sent by users are validated before being encoded, so this
+    // should be used by encoders and commands to validate any text that
+    // they derive from a message (for example, a new topic).
+    Validate(text string) (string, error)
+
+    // HasPermission check whether `username` is allowed to use `perm` in
+    // this channel.
+    //
+    // The channel itself only checks `PermSend`, after encoding the
+    // message. Other permissions should be checked by the application
+    // (for example, by its commands) before calling the associated
+    // method, like `Ban` or `Close`.
+    HasPermission(username string, perm Permission) bool
+
+    // AccessMode retrieve which users may connect to this channel.
+    AccessMode() AccessMode
+
+    // SetAccessMode change which users may connect to this channel. Users
+    // already connected aren't affected.
+    //
+    // Users invited to the channel, and users allowed to use
+    // `PermAccess`, may always connect to the channel (as long as they
+    // aren't banned).
+    SetAccessMode(mode AccessMode)
+
+    // SetPassphrase change the passphrase required to connect to this
+    // channel, while it's in `AccessPassphrase` mode. The passphrase is
+    // checked when the token is requested (see `TokenOptions.Passphrase`),
+    // and tokens issued with a previous passphrase are rejected.
+    //
+    // If `passphrase` is empty, only invited users may connect to the
+    // channel.
+    SetPassphrase(passphrase string) error
+
+    // Invite `username` to this channel, so they may connect to it
+    // regardless of its access mode.
+    Invite(username string)
+
+    // Uninvite `username` from this channel. If the user is currently
+    // connected, they aren't removed from the channel.
+    Uninvite(username string)
+
+    // ListInvited retrieve the users invited to this channel, sorted by
+    // name.
+    ListInvited() []string
+
+    // Ban `username` from this channel for `duration`, removing them from
+    // the channel if they are connected. If `duration` isn't positive, the
+    // ban never expires.
+    //
+    // Banned users are refused with `UserBanned` when connecting.
+    Ban(username string, duration time.Duration) error
+
+    // Unban `username` from this channel.
+    //
+    // Fails with `InvalidUser` if the user isn't banned.
+    Unban(username string) error
+
+    // ListBans retrieve every user currently banned from this channel.
+    ListBans() []Sanction
+
+    // Mute `username` in this channel for `duration`, so their broadcasts
+    // get dropped before being encoded. If `duration` isn't positive, the
+    // mute never expires.
+    Mute(username string, duration time.Duration) error
+
+    // Unmute `username` in this channel.
+    //
+    // Fails with `InvalidUser` if the user isn't muted.
+    Unmute(username string) error
+
+    // ListMutes retrieve every user currently muted in this channel.
+    ListMutes() []Sanction
+
+    // GetClaims retrieve a copy of the claims of `username`, as supplied
+    // when they connected to this channel (for example, by the token used
+    // to connect).
+    //
+    // Fails with `InvalidUser` if the user isn't connected to the channel.
+    GetClaims(username string) (map[string]string, error)
+
+    // GetHistory retrieve a copy of the, at most, `limit` newest messages
+    // broadcast by this channel after `since`, from the oldest to the
+    // newest.
+    //
+    // If `since` is the zero time, every message in the history is
+    // considered. Similarly, if `limit` isn't positive, every message is
+    // returned. Only the last `ServerConf.HistorySize` messages are kept
+    // by the channel.
+    GetHistory(since time.Time, limit int) []Message
+
     // ConnectUser add a new user to the channel.
     //
     // It's entirely up to the caller to initialize the connection used by
@@ -563,14 +1295,85 @@ type ChatChannel interface {
     //
     // If `conn` is nil, then this function will panic!
     ConnectUserAndWait(username string, conn Conn) error
+
+    // ConnectUserWithOptions add a new user to the channel, as customized
+    // by `opts`.
+    //
+    // See `ConnectUser` for a more complete description.
+    ConnectUserWithOptions(username string, conn Conn,
+            opts ConnectOptions) error
+
+    // ConnectUserWithOptionsAndWait add a new user to the channel, as
+    // customized by `opts`, and blocks until the user closes the
+    // connection to the server.
+    //
+    // See `ConnectUserAndWait` for a more complete description.
+    ConnectUserWithOptionsAndWait(username string, conn Conn,
+            opts ConnectOptions) error
+}
+
+// load the channel's previous messages from `store` into its history,
+// reopening the channel, and use `store` to persist new messages.
+//
+// This must be called before the channel starts running.
+func (c *channel) load(store MessageStore) {
+    if store == nil {
+        return
+    }
+    c.store = store
+
+    query := StoreQuery {}
+    if c.history != nil {
+        query.Limit = len(c.history.entries)
+    } else {
+        // Only the last message is needed to restore the sequence number.
+        query.Limit = 1
+    }
+
+    list, err := store.Range(c.name, query)
+    if err != nil {
+        if c.logger != nil {
+            c.logger.Printf("[ERROR] go_chat_i_guess/channel: Couldn't load the channel's stored messages.\n\tchannel: \"%s\"\n\terror: %+v",
+                    c.name, err)
+        }
+        return
+    }
+
+    for i := range list {
+        msg := &list[i]
+        c.seq = msg.Seq
+
+        if c.history == nil {
+            continue
+        }
+
+        // Stored messages were already processed when they were sent.
+        msg.encoded = true
+        msgStr := c.encode(msg)
+        if len(msgStr) > 0 {
+            c.history.push(msg, msgStr)
+        }
+
+        // Similarly, replies must be counted on messages that couldn't
+        // be updated in the store.
+        if _, ok := store.(MessageUpdater); !ok && len(msg.ReplyTo) > 0 {
+            c.addReply(msg, false)
+        }
+    }
+
+    if c.debugLog && c.logger != nil && len(list) > 0 {
+        c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Reopened channel with stored messages...\n\tchannel: \"%s\"\n\tmessages: %d\n\tseq: %d",
+                c.name, len(list), c.seq)
+    }
 }
 
-// newChannel create a new ChatChannel named `name`.
+// newChannel create a new ChatChannel named `name`. If `owner` isn't
+// empty, that user is given the `RoleOwner` in the channel.
 //
-// A `Controller` may optionally be supplied on `conf` to process and
-// encode messages received by the channel. Additionally, if this
-// `Controller` implements `ChannelController` as well, then it will also
-// be used to process channel events.
+// An `Encoder` or a `Controller` may optionally be supplied on `conf` to
+// process and encode messages received by the channel. Additionally, if
+// the supplied object implements `ChannelEvents` as well, then it will
+// also be used to process channel events.
 //
 // `newChannel()` executes a new goroutine to handle messages received by
 // the channel. To stop this goroutine and clean up its resources, call
@@ -579,12 +1382,22 @@ type ChatChannel interface {
 // Regardless, if every user disconnects and the channel is left idle for
 // long enough (more specifically, for `defIdleTimeout`), this goroutine
 // will automatically stop.
-func newChannel(name string, conf ServerConf) ChatChannel {
+func newChannel(name, owner string, conf ServerConf) ChatChannel {
     c := &channel {
         name: name,
-        encoder: conf.Controller,
-        recv: make(chan *message, 8),
+        recv: make(chan *Message, 8),
+        wake: make(chan struct{}, 1),
+        history: newHistory(conf.HistorySize),
+        historyReplay: conf.HistoryReplay,
         idleTimeout: conf.ChannelIdleTimeout,
+        sendQueueSize: conf.SendQueueSize,
+        sendQueuePolicy: conf.SendQueuePolicy,
+        sessionPolicy: conf.SessionPolicy,
+        roles: newRoles(conf.Permissions, owner),
+        bans: newSanctions(),
+        mutes: newSanctions(),
+        access: newAccess(),
+        validators: conf.Validators,
         users: make(map[string]*user),
         running: 1,
         idle: time.NewTicker(conf.ChannelIdleTimeout),
@@ -593,19 +1406,39 @@ func newChannel(name string, conf ServerConf) ChatChannel {
         debugLog: conf.DebugLog,
     }
 
-    // Optionally, check if Controller also implements `ChannelController`
-    // and store that as well.
-    if conf.Controller != nil {
-        if ctrl, ok := conf.Controller.(ChannelController); ok {
-            if conf.DebugLog && conf.Logger != nil {
-                conf.Logger.Printf("[DEBUG] go_chat_i_guess/channel: Using a message controller...\n\tchannel: \"%s\"",
-                        c.name)
-            }
+    // Prefer the `Encoder`, falling back to the (adapted) `Controller`.
+    var ctrl interface{}
+    if conf.Encoder != nil {
+        c.encoder = conf.Encoder
+        ctrl = conf.Encoder
+    } else if conf.Controller != nil {
+        c.encoder = AdaptEncoder(conf.Controller)
+        ctrl = conf.Controller
+    }
 
-            c.controller = ctrl
+    // Optionally, check if the encoder also implements `ChannelEvents`
+    // and store that as well.
+    if events, ok := ctrl.(ChannelEvents); ok {
+        if conf.DebugLog && conf.Logger != nil {
+            conf.Logger.Printf("[DEBUG] go_chat_i_guess/channel: Using a message controller...\n\tchannel: \"%s\"",
+                    c.name)
         }
+
+        c.events = events
+    } else {
+        c.events = defaultEvents {}
+    }
+
+    if conf.FloodControl.User.enabled() || conf.FloodControl.Channel.enabled() {
+        c.flood = newFlood(conf.FloodControl)
     }
 
+    c.meta.creator = owner
+    c.meta.created = time.Now()
+    c.meta.lastActivity = c.meta.created
+
+    c.load(conf.Store)
+
     go c.run()
 
     return c
@@ -2,6 +2,7 @@ package main
 
 import (
     "encoding/base64"
+    "encoding/json"
     "fmt"
     gochat "github.com/SirGFM/go-chat-i-guess"
     "io"
@@ -10,6 +11,7 @@ import (
     "net/url"
     "os"
     "path"
+    "strconv"
     "strings"
     "time"
 )
@@ -53,7 +55,9 @@ func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
                 httpTextReply(http.StatusInternalServerError, fmt.Sprintf("Couldn't create the channel: %+v", err), w)
                 log.Printf("%s - %s - %s [500]", req.RemoteAddr, req.Method, uri)
             }
-        } else if len(parts) == 3 && parts[0] == "new_token" {
+        } else if (len(parts) == 3 || len(parts) == 4) && parts[0] == "new_token" {
+            // The channel's passphrase may optionally be sent as a fourth
+            // component, as in '/new_token/<channel>/<username>/<passphrase>'.
             channel, err := decodeB64(parts[1])
             if err != nil {
                 httpTextReply(http.StatusInternalServerError, fmt.Sprintf("Couldn't get the new token's channel: %+v", err), w)
@@ -65,7 +69,17 @@ func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
                 log.Printf("%s - %s - %s [500]", req.RemoteAddr, req.Method, uri)
             }
 
-            tk, err := s.chat.RequestToken(username, channel)
+            var opts gochat.TokenOptions
+            if len(parts) == 4 {
+                opts.Passphrase, err = decodeB64(parts[3])
+                if err != nil {
+                    httpTextReply(http.StatusInternalServerError, fmt.Sprintf("Couldn't get the new token's passphrase: %+v", err), w)
+                    log.Printf("%s - %s - %s [500]", req.RemoteAddr, req.Method, uri)
+                    return
+                }
+            }
+
+            tk, err := s.chat.RequestTokenWithOptions(username, channel, opts)
             if err == nil {
                 httpTextReply(http.StatusOK, tk, w)
                 log.Printf("%s - %s - %s [OK]", req.RemoteAddr, req.Method, uri)
@@ -73,6 +87,29 @@ func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
                 httpTextReply(http.StatusInternalServerError, fmt.Sprintf("Couldn't create the token: %+v", err), w)
                 log.Printf("%s - %s - %s [500]", req.RemoteAddr, req.Method, uri)
             }
+        } else if len(parts) == 1 && parts[0] == "list_channels" {
+            // The lobby may be filtered and paginated through the query
+            // string, as in '/list_channels?prefix=a&offset=10&limit=10'
+            query := req.URL.Query()
+            filter := gochat.ChannelFilter {
+                Prefix: query.Get("prefix"),
+                Contains: query.Get("contains"),
+                SkipPrivate: true,
+            }
+            filter.Offset, _ = strconv.Atoi(query.Get("offset"))
+            filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+
+            data, err := json.Marshal(s.chat.ListChannels(filter))
+            if err != nil {
+                httpTextReply(http.StatusInternalServerError, fmt.Sprintf("Couldn't list the channels: %+v", err), w)
+                log.Printf("%s - %s - %s [500]", req.RemoteAddr, req.Method, uri)
+                return
+            }
+
+            w.Header().Set("Content-Type", "application/json")
+            w.WriteHeader(http.StatusOK)
+            w.Write(data)
+            log.Printf("%s - %s - %s [OK]", req.RemoteAddr, req.Method, uri)
         } else if len(parts) == 1 && parts[0] == "chat" {
             // '/chat' expects the token to be sent in a 'X-ChatToken' cookie
             tk := ""
@@ -162,33 +199,11 @@ func (s *server) OnDisconnect(channel gochat.RestrictedChatChannel,
     channel.NewSystemBroadcast(username + " has exited " + channel.Name())
 }
 
-// Encode the received message.
+// Encode the received message. Commands are handled by the
+// `gochat.CommandRouter` before the message gets here.
 func (s *server) Encode(channel gochat.ChatChannel, date time.Time, msg,
         from, to string) string {
 
-    // Try to parse the message as a command.
-    switch msg {
-    case "/users":
-        // Return the list of users only for the requesting user.
-        msg := "Users in channel '" + channel.Name() + "': "
-        for _, name := range channel.GetUsers(nil) {
-            msg += name + ", "
-        }
-        // Remove the trailing ", ".
-        msg = msg[:len(msg)-2]
-        channel.NewSystemWhisper(msg, from)
-        // Don't broadcast this message.
-        return ""
-    case "/quit":
-        // Try to quit from the channel, and filter the original message.
-        err := channel.RemoveUser(from)
-        if err != nil {
-            channel.NewSystemWhisper("Couldn't quit from the channel", from)
-        }
-        return ""
-    }
-
-    // Otherwise, use the default encoding.
     t := date.Format("2006-01-02 - 15:04:05 (-0700)")
     u := ""
     if len(from) > 0 {
@@ -206,7 +221,7 @@ func runWeb(args Args) io.Closer {
         Handler: &srv,
     }
     conf := gochat.GetDefaultServerConf()
-    conf.Controller = &srv
+    conf.Encoder = gochat.NewCommandRouter(&srv)
     conf.Logger = log.New(os.Stdout, "chat-server: ", log.Lshortfile | log.Ldate | log.Ltime | log.Lmicroseconds | log.Lmsgprefix)
     conf.DebugLog = args.Debug
     srv.chat = gochat.NewServerConf(conf)
@@ -0,0 +1,444 @@
+package go_chat_i_guess
+
+import (
+    "sort"
+    "strings"
+    "sync"
+)
+
+// CommandContext describes a command being executed.
+type CommandContext struct {
+    // Channel where the command was sent.
+    Channel ChatChannel
+
+    // Message that invoked the command. The handler may modify it before
+    // it gets broadcast.
+    Message *Message
+
+    // Args parsed from the message, as defined by the `Command`.
+    Args []string
+}
+
+// CommandHandler executes a command.
+//
+// If the handler returns true, `ctx.Message` (which may have been
+// modified by the handler) is encoded and broadcast as any other message.
+// Otherwise, the message is filtered out.
+//
+// Returning an error cancels the message and whispers the error back to
+// the sender.
+//
+// Handlers are called while the channel encodes the message, so messages
+// queued through `ctx.Channel` are only handled after the command.
+type CommandHandler func(ctx *CommandContext) (bool, error)
+
+// Command that may be sent to a channel, as "/name arg1 arg2...".
+type Command struct {
+    // Name of the command, without the leading '/'.
+    Name string
+
+    // Usage of the command, shown by "/help" and when the command is
+    // called with an invalid number of arguments.
+    Usage string
+
+    // Description of the command, shown by "/help".
+    Description string
+
+    // MinArgs is the minimum number of arguments accepted by the command.
+    MinArgs int
+
+    // MaxArgs is the maximum number of arguments accepted by the command.
+    // Arguments are separated by whitespace, except for the last one,
+    // which receives the remainder of the message.
+    MaxArgs int
+
+    // Role is the lowest role allowed to execute the command.
+    Role Role
+
+    // Handler executes the command.
+    Handler CommandHandler
+}
+
+// parseArgs split `text` into the command's arguments, returning whether
+// the number of arguments is valid.
+func (cmd *Command) parseArgs(text string) ([]string, bool) {
+    var args []string
+
+    text = strings.TrimSpace(text)
+    for len(text) > 0 {
+        if len(args) == cmd.MaxArgs {
+            // Too many arguments.
+            return args, false
+        } else if len(args) == cmd.MaxArgs - 1 {
+            args = append(args, text)
+            break
+        }
+
+        idx := strings.IndexAny(text, " \t\n")
+        if idx == -1 {
+            args = append(args, text)
+            break
+        }
+        args = append(args, text[:idx])
+        text = strings.TrimSpace(text[idx:])
+    }
+
+    return args, len(args) >= cmd.MinArgs
+}
+
+// CommandRouter is a `MessageEncoderV2` that executes the commands sent
+// to a channel, forwarding every other message to the wrapped encoder.
+//
+// Messages starting with a '/' are considered to be commands.
+//
+// Unknown commands aren't broadcast. Instead, the sender is notified
+// through a system whisper.
+type CommandRouter struct {
+    // next encodes messages that aren't commands. If nil,
+    // `Message.Encode()` is used instead.
+    next MessageEncoderV2
+
+    // events processes the channel's events.
+    events ChannelEvents
+
+    // commands registered in the router, indexed by their names.
+    commands map[string]*Command
+
+    // lock synchronizes access to `commands`.
+    lock sync.RWMutex
+}
+
+// Register a new command in the router, replacing any previous command
+// with the same name.
+func (r *CommandRouter) Register(cmd Command) {
+    r.lock.Lock()
+    r.commands[cmd.Name] = &cmd
+    r.lock.Unlock()
+}
+
+// Unregister the command `name` from the router.
+func (r *CommandRouter) Unregister(name string) {
+    r.lock.Lock()
+    delete(r.commands, name)
+    r.lock.Unlock()
+}
+
+// Commands retrieve every command registered in the router, sorted by
+// name.
+func (r *CommandRouter) Commands() []Command {
+    var list []Command
+
+    r.lock.RLock()
+    for _, cmd := range r.commands {
+        list = append(list, *cmd)
+    }
+    r.lock.RUnlock()
+
+    sort.Slice(list, func(i, j int) bool {
+        return list[i].Name < list[j].Name
+    })
+
+    return list
+}
+
+// OnConnect forwards the event to the wrapped encoder, if it implements
+// `ChannelEvents`. Otherwise, the channel's default event is broadcast.
+func (r *CommandRouter) OnConnect(channel ChatChannel, username string) {
+    r.events.OnConnect(channel, username)
+}
+
+// OnDisconnect forwards the event to the wrapped encoder, if it
+// implements `ChannelEvents`. Otherwise, the channel's default event is
+// broadcast.
+func (r *CommandRouter) OnDisconnect(channel RestrictedChatChannel,
+        username string) {
+    r.events.OnDisconnect(channel, username)
+}
+
+// EncodeMessage execute the command in `msg`, if it's a command, and
+// encode it using the wrapped encoder, if it should be broadcast.
+func (r *CommandRouter) EncodeMessage(channel ChatChannel, msg *Message) string {
+    if msg.Kind == KindUser && !msg.revised() && strings.HasPrefix(msg.Message, "/") {
+        if !r.execute(channel, msg) {
+            return ""
+        }
+    }
+
+    if r.next == nil {
+        return msg.Encode()
+    }
+    return r.next.EncodeMessage(channel, msg)
+}
+
+// execute the command in `msg`, returning whether the message should be
+// broadcast.
+func (r *CommandRouter) execute(channel ChatChannel, msg *Message) bool {
+    text := msg.Message[1:]
+    name := text
+    if idx := strings.IndexAny(text, " \t\n"); idx != -1 {
+        name = text[:idx]
+        text = text[idx:]
+    } else {
+        text = ""
+    }
+
+    r.lock.RLock()
+    cmd, ok := r.commands[name]
+    r.lock.RUnlock()
+
+    if !ok {
+        channel.NewSystemWhisper("Unknown command: /" + name + ". Try /help.",
+                msg.From)
+        return false
+    } else if channel.GetRole(msg.From) < cmd.Role {
+        channel.NewSystemWhisper("You aren't allowed to use /" + name + ".",
+                msg.From)
+        return false
+    }
+
+    args, ok := cmd.parseArgs(text)
+    if !ok {
+        channel.NewSystemWhisper("Usage: " + cmd.Usage, msg.From)
+        return false
+    }
+
+    ctx := CommandContext {
+        Channel: channel,
+        Message: msg,
+        Args: args,
+    }
+    broadcast, err := cmd.Handler(&ctx)
+    if err != nil {
+        channel.NewSystemWhisper("/" + name + " failed: " + err.Error(),
+                msg.From)
+        return false
+    }
+
+    return broadcast
+}
+
+// registerBuiltins register the commands that come with every router.
+func (r *CommandRouter) registerBuiltins() {
+    r.Register(Command {
+        Name: "users",
+        Usage: "/users",
+        Description: "List the users in the channel.",
+        Role: RoleGuest,
+        Handler: cmdUsers,
+    })
+    r.Register(Command {
+        Name: "quit",
+        Usage: "/quit",
+        Description: "Leave the channel.",
+        Role: RoleGuest,
+        Handler: cmdQuit,
+    })
+    r.Register(Command {
+        Name: "me",
+        Usage: "/me <action>",
+        Description: "Broadcast an action.",
+        MinArgs: 1,
+        MaxArgs: 1,
+        Role: RoleMember,
+        Handler: cmdMe,
+    })
+    r.Register(Command {
+        Name: "whisper",
+        Usage: "/whisper <user> <message>",
+        Description: "Send a private message to another user in the channel.",
+        MinArgs: 2,
+        MaxArgs: 2,
+        Role: RoleMember,
+        Handler: cmdWhisper,
+    })
+    r.Register(Command {
+        Name: "topic",
+        Usage: "/topic [new topic]",
+        Description: "Show the channel's topic and description, or change its topic.",
+        MaxArgs: 1,
+        Role: RoleGuest,
+        Handler: cmdTopic,
+    })
+    r.Register(Command {
+        Name: "invite",
+        Usage: "/invite <user>",
+        Description: "Allow a user to connect to the channel, regardless of its access mode.",
+        MinArgs: 1,
+        MaxArgs: 1,
+        Role: RoleMember,
+        Handler: cmdInvite,
+    })
+    r.Register(Command {
+        Name: "uninvite",
+        Usage: "/uninvite <user>",
+        Description: "Revoke a user's invitation to the channel.",
+        MinArgs: 1,
+        MaxArgs: 1,
+        Role: RoleMember,
+        Handler: cmdUninvite,
+    })
+    r.Register(Command {
+        Name: "help",
+        Usage: "/help",
+        Description: "List the available commands.",
+        Role: RoleGuest,
+        Handler: r.cmdHelp,
+    })
+}
+
+// cmdUsers whisper the list of users in the channel to the sender.
+func cmdUsers(ctx *CommandContext) (bool, error) {
+    list := ctx.Channel.GetUsers(nil)
+    sort.Strings(list)
+
+    msg := "Users in channel '" + ctx.Channel.Name() + "': " + strings.Join(list, ", ")
+    ctx.Channel.NewSystemEvent(KindUserList, msg, ctx.Message.From, nil)
+
+    return false, nil
+}
+
+// cmdQuit remove the sender from the channel.
+func cmdQuit(ctx *CommandContext) (bool, error) {
+    return false, ctx.Channel.RemoveUser(ctx.Message.From)
+}
+
+// cmdMe broadcast the sender's action.
+func cmdMe(ctx *CommandContext) (bool, error) {
+    msg := ctx.Message
+    msg.Message = "* " + msg.From + " " + ctx.Args[0]
+    if msg.Metadata == nil {
+        msg.Metadata = make(map[string]string)
+    }
+    msg.Metadata["action"] = ctx.Args[0]
+
+    return true, nil
+}
+
+// cmdWhisper send a private message from the sender to another user.
+func cmdWhisper(ctx *CommandContext) (bool, error) {
+    ctx.Channel.NewWhisper(ctx.Args[1], ctx.Message.From, ctx.Args[0])
+    return false, nil
+}
+
+// cmdTopic whisper the channel's metadata to the sender or, if an
+// argument was supplied, change the channel's topic.
+func cmdTopic(ctx *CommandContext) (bool, error) {
+    from := ctx.Message.From
+
+    if len(ctx.Args) == 0 {
+        info := ctx.Channel.Info()
+
+        topic := info.Topic
+        if len(topic) == 0 {
+            topic = "(none)"
+        }
+        msg := "Topic of '" + info.Name + "': " + topic
+        if len(info.Description) > 0 {
+            msg += "\nDescription: " + info.Description
+        }
+        if len(info.Creator) > 0 {
+            msg += "\nCreated by " + info.Creator + " on " + info.Created.Format("2006-01-02 15:04:05 (-0700)")
+        } else {
+            msg += "\nCreated on " + info.Created.Format("2006-01-02 15:04:05 (-0700)")
+        }
+
+        ctx.Channel.NewSystemWhisper(msg, from)
+        return false, nil
+    } else if !ctx.Channel.HasPermission(from, PermSetTopic) {
+        return false, PermissionDenied
+    }
+
+    topic, err := ctx.Channel.Validate(ctx.Args[0])
+    if err != nil {
+        return false, err
+    }
+
+    ctx.Channel.SetTopic(topic, from)
+    return false, nil
+}
+
+// cmdInvite invite a user to the channel.
+func cmdInvite(ctx *CommandContext) (bool, error) {
+    from := ctx.Message.From
+    if !ctx.Channel.HasPermission(from, PermAccess) {
+        return false, PermissionDenied
+    }
+
+    ctx.Channel.Invite(ctx.Args[0])
+    ctx.Channel.NewSystemWhisper(ctx.Args[0] + " was invited to " + ctx.Channel.Name() + ".",
+            from)
+    return false, nil
+}
+
+// cmdUninvite revoke a user's invitation to the channel.
+func cmdUninvite(ctx *CommandContext) (bool, error) {
+    from := ctx.Message.From
+    if !ctx.Channel.HasPermission(from, PermAccess) {
+        return false, PermissionDenied
+    }
+
+    ctx.Channel.Uninvite(ctx.Args[0])
+    ctx.Channel.NewSystemWhisper(ctx.Args[0] + " is no longer invited to " + ctx.Channel.Name() + ".",
+            from)
+    return false, nil
+}
+
+// cmdHelp whisper the commands available to the sender.
+func (r *CommandRouter) cmdHelp(ctx *CommandContext) (bool, error) {
+    role := ctx.Channel.GetRole(ctx.Message.From)
+
+    msg := "Available commands:"
+    for _, cmd := range r.Commands() {
+        if role >= cmd.Role {
+            msg += "\n" + cmd.Usage + ": " + cmd.Description
+        }
+    }
+    ctx.Channel.NewSystemWhisper(msg, ctx.Message.From)
+
+    return false, nil
+}
+
+// NewCommandRouterV2 create a new `CommandRouter`, with every built-in
+// command registered, that encodes messages using `next`. If `next` is
+// nil, messages are encoded with `Message.Encode()`.
+//
+// The built-in commands are:
+//
+//  - /users: List the users in the channel
+//  - /quit: Leave the channel
+//  - /me <action>: Broadcast an action
+//  - /whisper <user> <message>: Send a private message to another user
+//  - /topic [new topic]: Show or change the channel's topic
+//  - /invite <user>: Allow a user to connect to the channel
+//  - /uninvite <user>: Revoke a user's invitation to the channel
+//  - /help: List the available commands
+//
+// If `next` implements `ChannelEvents`, the router forwards the channel's
+// events to it.
+func NewCommandRouterV2(next MessageEncoderV2) *CommandRouter {
+    r := &CommandRouter {
+        next: next,
+        events: defaultEvents {},
+        commands: make(map[string]*Command),
+    }
+    if events, ok := next.(ChannelEvents); ok {
+        r.events = events
+    }
+
+    r.registerBuiltins()
+
+    return r
+}
+
+// NewCommandRouter create a new `CommandRouter` that wraps a
+// `MessageEncoder`.
+//
+// See `NewCommandRouterV2` for a more complete description.
+func NewCommandRouter(next MessageEncoder) *CommandRouter {
+    r := NewCommandRouterV2(AdaptEncoder(next))
+    if events, ok := next.(ChannelEvents); ok {
+        r.events = events
+    }
+
+    return r
+}
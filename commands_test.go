@@ -0,0 +1,202 @@
+package go_chat_i_guess
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// TestParseArgs check whether commands' arguments are correctly parsed.
+func TestParseArgs(t *testing.T) {
+    tests := []struct {
+        min int
+        max int
+        text string
+        want []string
+        ok bool
+    } {
+        { 0, 0, "", nil, true },
+        { 0, 0, " extra", nil, false },
+        { 1, 1, " the whole  text ", []string { "the whole  text" }, true },
+        { 2, 2, " bob  hello there", []string { "bob", "hello there" }, true },
+        { 2, 2, "bob", []string { "bob" }, false },
+        { 0, 1, "", nil, true },
+    }
+
+    for _, test := range tests {
+        cmd := Command { MinArgs: test.min, MaxArgs: test.max }
+        args, ok := cmd.parseArgs(test.text)
+        if test.ok != ok {
+            t.Errorf("Invalid result for '%s': expected '%v' but got '%v'", test.text, test.ok, ok)
+        } else if ok && strings.Join(test.want, "|") != strings.Join(args, "|") {
+            t.Errorf("Invalid arguments for '%s': expected '%+v' but got '%+v'", test.text, test.want, args)
+        }
+    }
+}
+
+// TestCommandRouter check whether commands are correctly routed.
+func TestCommandRouter(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    router := NewCommandRouter(nil)
+    router.Register(Command {
+        Name: "shout",
+        Usage: "/shout <message>",
+        MinArgs: 1,
+        MaxArgs: 1,
+        Role: RoleOperator,
+        Handler: func(ctx *CommandContext) (bool, error) {
+            ctx.Message.Message = strings.ToUpper(ctx.Args[0])
+            return true, nil
+        },
+    })
+    conf.Encoder = router
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+    c.ConnectUser(u1, c1)
+    _c1.TestRecv(time.Millisecond * 5)
+    c.ConnectUser(u2, c2)
+    _c1.TestRecv(time.Millisecond * 5)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    tests := []struct {
+        in string
+        want string
+        broadcast bool
+    } {
+        { "/users", u2, false },
+        { "/dance", "Unknown command", false },
+        { "/me waves", "* user1 waves", true },
+        { "/whisper", "Usage", false },
+        { "/whisper user2 hi there", "hi there", false },
+        { "/topic", "(none)", false },
+        { "/topic chat", "permission", false },
+        { "/shout hi", "allowed", false },
+        { "/help", "/users", false },
+    }
+    for _, test := range tests {
+        _c1.TestSend(test.in)
+        msg, err := _c1.TestRecv(time.Millisecond * 5)
+        if err != nil {
+            t.Errorf("%s didn't receive a reply for '%s': %+v", u1, test.in, err)
+        } else if !strings.Contains(msg, test.want) {
+            t.Errorf("Invalid reply for '%s': expected '%s' but got '%s'", test.in, test.want, msg)
+        }
+        if strings.HasPrefix(test.in, "/whisper ") {
+            msg, err = _c2.TestRecv(time.Millisecond * 5)
+            if err != nil || !strings.Contains(msg, "hi there") {
+                t.Errorf("%s didn't receive the whisper: %s, %+v", u2, msg, err)
+            }
+        }
+        if msg, err := _c2.TestRecv(time.Millisecond); test.broadcast && err != nil {
+            t.Errorf("%s didn't receive the broadcast for '%s': %+v", u2, test.in, err)
+        } else if !test.broadcast && err == nil {
+            t.Errorf("Reply for '%s' was broadcast: %s", test.in, msg)
+        }
+    }
+
+    // Operators may use /shout and change the topic.
+    c.SetRole(u1, RoleOperator)
+    _c1.TestSend("/shout hi")
+    if msg, _ := _c2.TestRecv(time.Millisecond * 5); !strings.HasSuffix(msg, "HI") {
+        t.Errorf("Invalid shout: %s", msg)
+    }
+    _c1.TestSend("/topic chat")
+    if msg, _ := _c2.TestRecv(time.Millisecond * 5); !strings.Contains(msg, "chat") {
+        t.Errorf("Topic change wasn't broadcast: %s", msg)
+    } else if want, got := "chat", c.Topic(); want != got {
+        t.Errorf("Invalid topic: expected '%s' but got '%s'", want, got)
+    }
+
+    // /quit should remove the user from the channel.
+    _c2.TestSend("/quit")
+    time.Sleep(time.Millisecond * 5)
+    if list := c.GetUsers(nil); len(list) != 1 {
+        t.Errorf("%s didn't quit: %+v", u2, list)
+    }
+}
+
+// gatedEncoder is a `MessageEncoderV2` that blocks while encoding the
+// message "gate", until `gate` gets closed. Messages are encoded with
+// `next` or, if it's nil, with `Message.Encode()`.
+type gatedEncoder struct {
+    next MessageEncoderV2
+    gate chan struct{}
+}
+
+func (e gatedEncoder) EncodeMessage(channel ChatChannel, msg *Message) string {
+    if msg.Message == "gate" {
+        <-e.gate
+    }
+
+    if e.next == nil {
+        return msg.Encode()
+    }
+    return e.next.EncodeMessage(channel, msg)
+}
+
+// sendSaturated send `text` from `from` to `c`, whose encoder must be a
+// `gatedEncoder` using `gate`, and keep the channel's queue full (with
+// other senders waiting for it) while the message is handled.
+func sendSaturated(c ChatChannel, gate chan struct{}, from, text string) {
+    c.NewBroadcast("gate", from)
+    c.NewBroadcast(text, from)
+    for i := 0; i < 12; i++ {
+        go c.NewBroadcast("fill", from)
+    }
+
+    time.Sleep(time.Millisecond * 5)
+    close(gate)
+}
+
+// recvContaining wait for `conn` to receive a message containing `want`,
+// skipping every other message.
+func recvContaining(conn *mockConn, want string) (string, bool) {
+    for i := 0; i < 32; i++ {
+        msg, err := conn.TestRecv(time.Second)
+        if err != nil {
+            return "", false
+        } else if strings.Contains(msg, want) {
+            return msg, true
+        }
+    }
+    return "", false
+}
+
+// TestCommandRouterSaturated check whether commands may reply to their
+// senders while the channel's queue is full.
+func TestCommandRouterSaturated(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+
+    gate := make(chan struct{})
+
+    conf := GetDefaultServerConf()
+    conf.Encoder = NewCommandRouterV2(gatedEncoder { nil, gate })
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c.ConnectUser(u1, c1)
+    _c1.TestRecv(time.Millisecond * 5)
+
+    sendSaturated(c, gate, u1, "/nope")
+    if _, ok := recvContaining(_c1, "Unknown command"); !ok {
+        t.Fatalf("The channel stalled while replying to an unknown command")
+    }
+}
@@ -0,0 +1,109 @@
+package go_chat_i_guess
+
+// deferredChannel is the `ChatChannel` handed to the application by the
+// channel's goroutine (for example, to encode a message or to report a
+// user leaving).
+//
+// The channel's goroutine is the one that empties the channel's queue,
+// so it can't wait for the queue to have room. Instead, messages queued
+// through a `deferredChannel` are added to the channel's backlog, and
+// handled as soon as the goroutine finishes handling the current
+// message. Every other method is forwarded to the channel itself.
+type deferredChannel struct {
+    *channel
+}
+
+// queue `packet` to be handled by the channel's goroutine, after the
+// message currently being handled.
+func (d deferredChannel) queue(packet *Message) {
+    d.later(func() {
+        d.handleMessage(packet)
+    })
+}
+
+// NewBroadcast queue a new broadcast message from a specific sender,
+// without blocking.
+func (d deferredChannel) NewBroadcast(msg, from string) {
+    d.queue(d.makeMessage(KindUser, msg, from, "", nil))
+}
+
+// NewSystemBroadcast queue a new system message, without blocking.
+func (d deferredChannel) NewSystemBroadcast(msg string) {
+    d.queue(d.makeMessage(KindSystem, msg, "", "", nil))
+}
+
+// NewWhisper queue a new private message from `from` to `to`, without
+// blocking.
+func (d deferredChannel) NewWhisper(msg, from, to string) {
+    d.queue(d.makeMessage(KindWhisper, msg, from, to, nil))
+}
+
+// NewSystemWhisper queue a new system message to a specific receiver,
+// without blocking.
+func (d deferredChannel) NewSystemWhisper(msg, to string) {
+    d.queue(d.makeMessage(KindWhisper, msg, "", to, nil))
+}
+
+// NewSystemEvent queue a new system message of the given `kind`, without
+// blocking.
+func (d deferredChannel) NewSystemEvent(kind MessageKind, msg, to string,
+        metadata map[string]string) {
+    d.queue(d.makeMessage(kind, msg, "", to, metadata))
+}
+
+// SetTopic change the topic of this channel to `topic`, queueing the
+// `KindTopic` event without blocking.
+func (d deferredChannel) SetTopic(topic, by string) {
+    d.queue(d.setTopic(topic, by))
+}
+
+// EditMessage replace the text of the message identified by `id` with
+// `text`, queueing the `KindEdit` event without blocking.
+func (d deferredChannel) EditMessage(id, from, text string) error {
+    event, err := d.editEvent(id, from, text)
+    if err == nil {
+        d.queue(event)
+    }
+    return err
+}
+
+// DeleteMessage delete the message identified by `id`, queueing the
+// `KindDelete` event without blocking.
+func (d deferredChannel) DeleteMessage(id, by string) error {
+    event, err := d.deleteEvent(id, by)
+    if err == nil {
+        d.queue(event)
+    }
+    return err
+}
+
+// AddReaction react to the message identified by `id` with `emoji`,
+// queueing the `KindReaction` event without blocking.
+func (d deferredChannel) AddReaction(id, username, emoji string) error {
+    event, err := d.reactionEvent(id, username, emoji, "add")
+    if err == nil {
+        d.queue(event)
+    }
+    return err
+}
+
+// RemoveReaction remove the reaction `emoji` of `username` from the
+// message identified by `id`, queueing the `KindReaction` event without
+// blocking.
+func (d deferredChannel) RemoveReaction(id, username, emoji string) error {
+    event, err := d.reactionEvent(id, username, emoji, "remove")
+    if err == nil {
+        d.queue(event)
+    }
+    return err
+}
+
+// NewReply queue a new broadcast message from `from`, replying to the
+// message identified by `replyTo`, without blocking.
+func (d deferredChannel) NewReply(msg, from, replyTo string) error {
+    packet, err := d.reply(msg, from, replyTo)
+    if err == nil {
+        d.queue(packet)
+    }
+    return err
+}
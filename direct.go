@@ -0,0 +1,78 @@
+package go_chat_i_guess
+
+import (
+    "strconv"
+)
+
+// DirectChannelName retrieve the name of the private channel shared by
+// `user1` and `user2`. The name doesn't depend on the order of the users.
+func DirectChannelName(user1, user2 string) string {
+    if user2 < user1 {
+        user1, user2 = user2, user1
+    }
+
+    return "dm:" + strconv.Quote(user1) + ":" + strconv.Quote(user2)
+}
+
+// SendDirect send a private message from `from` to every channel where
+// `to` is currently connected. The sender doesn't have to be connected to
+// any of those channels, nor does it receive a copy of the message.
+//
+// Fails with `InvalidUser` if `to` isn't connected to any channel.
+func (s *server) SendDirect(from, to, msg string) error {
+    var list []*channel
+
+    s.chanMutex.Lock()
+    for _, val := range s.channels {
+        if c, ok := val.(*channel); ok && !c.IsClosed() && c.hasUser(to) {
+            list = append(list, c)
+        }
+    }
+    s.chanMutex.Unlock()
+
+    if len(list) == 0 {
+        if s.conf.Logger != nil {
+            s.conf.Logger.Printf("[ERROR] go_chat_i_guess/server: Tried to send a direct message to a disconnected user.\n\tfrom: \"%s\"\n\tto: \"%s\"",
+                    from, to)
+        }
+        return InvalidUser
+    }
+
+    for _, c := range list {
+        c.newDirect(msg, from, to)
+    }
+
+    return nil
+}
+
+// OpenDirectChannel create the private channel shared by `user1` and
+// `user2`, if it isn't already running, and retrieve its name.
+//
+// See `ChatServer.OpenDirectChannel` for a more complete description.
+func (s *server) OpenDirectChannel(user1, user2 string) (string, error) {
+    if len(user1) == 0 || len(user2) == 0 || user1 == user2 {
+        return "", InvalidUser
+    }
+
+    name := DirectChannelName(user1, user2)
+
+    s.chanMutex.Lock()
+    defer s.chanMutex.Unlock()
+
+    if c, ok := s.channels[name]; ok && !c.IsClosed() {
+        return name, nil
+    }
+
+    if s.conf.DebugLog && s.conf.Logger != nil {
+        s.conf.Logger.Printf("[DEBUG] go_chat_i_guess/server: Opening a direct channel...\n\tchannel: \"%s\"",
+                name)
+    }
+
+    c := newChannel(name, "", s.conf)
+    c.SetAccessMode(AccessInviteOnly)
+    c.Invite(user1)
+    c.Invite(user2)
+    s.channels[name] = c
+
+    return name, nil
+}
@@ -74,7 +74,9 @@ requests.
 From this point onward, `Conn.Recv` blocks waiting for a message, which
 then gets forwarded to the `ChatChannel`. The `ChatChannel` then broadcasts
 this message to every connected user, including the sender, by calling
-their `Conn.SendStr`.
+their `Conn.SendStr`. Each user has its own send queue and goroutine, so
+a slow `Conn` doesn't stall the channel. The queue's depth and what
+happens when it overflows may be configured in the server's `ServerConf`.
 
 It's important to note that the although any `Conn` may be used for the
 connection, it's also used to identify the user within the `ChatChannel`.
@@ -84,8 +86,36 @@ associated user.
 
 By default, a Chat Server simply broadcasts the received messages as
 strings. This behaviour may be expanded by defining a `Encoder` in the
-server's `ServerConf`. This `MessageEncoder` could be used to process
-messages (for example, listing the users in a given channel) and/or to
-encode the message into a JSON object.
+server's `ServerConf`. This `MessageEncoderV2` receives the whole
+`Message` (its ID, sequence number, kind etc) and could be used to
+process messages (for example, listing the users in a given channel)
+and/or to encode the message into a JSON object. The older
+`MessageEncoder`, set as the `Controller`, is still supported.
+
+For a machine-readable protocol out of the box, set a `JSONEncoder` as the
+server's `Controller`. It encodes every message as a typed `JSONEnvelope`
+and parses messages sent by clients as `JSONCommand`s. Similarly, a
+`CommandRouter` may wrap any encoder to handle slash-commands, like
+"/users" or "/whisper", based on the role of the sender in the channel.
+
+Every channel also keeps a bounded history of its last broadcasts, which
+may be retrieved with `ChatChannel.GetHistory`. Setting `HistoryReplay` in
+the server's `ServerConf` makes the channel replay its last messages to
+users as soon as they join it. Every broadcast is also numbered by a
+monotonically increasing `Message.Seq`, so a client that got disconnected
+may request a token with `RequestResumeToken` to receive every message it
+missed.
+
+Users may also talk privately. Within a channel, `ChatChannel.NewWhisper`
+sends a message to a single user (and back to its sender). Across
+channels, `ChatServer.SendDirect` delivers a message to every channel
+where the receiver is connected, and `ChatServer.OpenDirectChannel`
+creates a private channel that only accepts its two users.
+
+By default, any user with a token may connect to a channel. A channel's
+`AccessMode` may restrict that to users invited by its owner
+(`AccessInviteOnly`), or to users that supply the channel's passphrase when
+requesting their tokens (`AccessPassphrase`, see `TokenOptions.Passphrase`).
+Either way, the access is enforced by `ChatServer.Connect`.
 */
 package go_chat_i_guess
@@ -0,0 +1,128 @@
+package go_chat_i_guess
+
+// findMessage retrieve a copy of the message identified by `id`, as long
+// as it's still kept in the channel's history and it wasn't deleted.
+func (c *channel) findMessage(id string) (Message, error) {
+    if c.history == nil {
+        return Message {}, MessageNotFound
+    }
+
+    msg, ok := c.history.find(id)
+    if !ok || msg.Deleted {
+        return Message {}, MessageNotFound
+    }
+
+    return msg, nil
+}
+
+// EditMessage replace the text of the message identified by `id` with
+// `text`, broadcasting the change as a `KindEdit` event.
+//
+// Only messages still kept in the channel's history may be edited, and
+// only by the user that sent them. The edit is subject to the same
+// restrictions (mutes, rate limits and validators) as new messages.
+//
+// Fails with `MessageNotFound` if the message isn't in the history, and
+// with `PermissionDenied` if `from` didn't send it.
+func (c *channel) EditMessage(id, from, text string) error {
+    event, err := c.editEvent(id, from, text)
+    if err == nil {
+        c.queue(event)
+    }
+    return err
+}
+
+// editEvent create the `KindEdit` event that replaces the text of the
+// message identified by `id`, failing like `EditMessage()`.
+func (c *channel) editEvent(id, from, text string) (*Message, error) {
+    msg, err := c.findMessage(id)
+    if err != nil {
+        return nil, err
+    } else if msg.Kind != KindUser || msg.From != from {
+        return nil, PermissionDenied
+    } else if !c.HasPermission(from, PermSend) {
+        return nil, PermissionDenied
+    }
+
+    return c.makeMessage(KindEdit, text, from, "", map[string]string {
+        "id": id,
+    }), nil
+}
+
+// DeleteMessage delete the message identified by `id`, broadcasting the
+// change as a `KindDelete` event.
+//
+// Only messages still kept in the channel's history may be deleted, either
+// by the user that sent them or by a user allowed to use `PermDelete`.
+//
+// Fails with `MessageNotFound` if the message isn't in the history, and
+// with `PermissionDenied` if `by` may not delete it.
+func (c *channel) DeleteMessage(id, by string) error {
+    event, err := c.deleteEvent(id, by)
+    if err == nil {
+        c.queue(event)
+    }
+    return err
+}
+
+// deleteEvent create the `KindDelete` event that deletes the message
+// identified by `id`, failing like `DeleteMessage()`.
+func (c *channel) deleteEvent(id, by string) (*Message, error) {
+    msg, err := c.findMessage(id)
+    if err != nil {
+        return nil, err
+    } else if msg.From != by && !c.HasPermission(by, PermDelete) {
+        return nil, PermissionDenied
+    }
+
+    return c.makeMessage(KindDelete, "", by, "", map[string]string {
+        "id": id,
+    }), nil
+}
+
+// revise apply the edit or delete event `event` to the message it
+// targets, updating the channel's history and, if it supports it, the
+// channel's store.
+//
+// This must only be called from the channel's goroutine.
+func (c *channel) revise(event *Message) error {
+    msg, err := c.findMessage(event.Metadata["id"])
+    if err != nil {
+        return err
+    }
+
+    if event.Kind == KindEdit {
+        msg.Message = event.Message
+        msg.Edited = true
+    } else {
+        msg.Message = ""
+        msg.Metadata = nil
+        msg.Deleted = true
+    }
+
+    c.rewrite(&msg, true)
+    return nil
+}
+
+// rewrite re-encode the changed message `msg`, replacing it in the
+// channel's history and, if `updateStore` is set and the store supports
+// it, in the channel's store.
+//
+// This must only be called from the channel's goroutine.
+func (c *channel) rewrite(msg *Message, updateStore bool) {
+    // The changed message must still be replayed, even if the encoder
+    // filters it out.
+    msgStr := c.encode(msg)
+    if len(msgStr) == 0 {
+        msgStr = msg.Encode()
+    }
+    c.history.update(msg, msgStr)
+
+    if updater, ok := c.store.(MessageUpdater); ok && updateStore {
+        err := updater.Update(c.name, *msg)
+        if err != nil && c.logger != nil {
+            c.logger.Printf("[ERROR] go_chat_i_guess/channel: Couldn't update the stored message.\n\tchannel: \"%s\"\n\tid: \"%s\"\n\terror: %+v",
+                    c.name, msg.ID, err)
+        }
+    }
+}
@@ -0,0 +1,179 @@
+package go_chat_i_guess
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// TestEditMessage check whether messages may be edited and deleted, and
+// whether the history and the store get updated.
+func TestEditMessage(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const u3 = "user3"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.Store = NewMemoryStore(conf.HistorySize)
+    conf.HistoryReplay = conf.HistorySize
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c.ConnectUser(u1, c1)
+    _c1.TestRecv(time.Millisecond * 5)
+
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+    c.ConnectUser(u2, c2)
+    _c1.TestRecv(time.Millisecond * 5)
+    // The second user also receives the first one joining, replayed.
+    _c2.TestRecv(time.Millisecond * 5)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    c.NewBroadcast("helo", u1)
+    _c1.TestRecv(time.Millisecond * 5)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    history := c.GetHistory(time.Time{}, 1)
+    if len(history) != 1 || history[0].Message != "helo" {
+        t.Fatalf("Invalid history: %+v", history)
+    }
+    id := history[0].ID
+
+    if err := c.EditMessage(id, u2, "hijacked"); err != PermissionDenied {
+        t.Errorf("Invalid error for editing another user's message! Expected '%+v' but got '%+v'", PermissionDenied, err)
+    }
+    if err := c.EditMessage("missing", u1, "hello"); err != MessageNotFound {
+        t.Errorf("Invalid error for editing a missing message! Expected '%+v' but got '%+v'", MessageNotFound, err)
+    }
+
+    if err := c.EditMessage(id, u1, "hello"); err != nil {
+        t.Fatalf("Couldn't edit the message: %+v", err)
+    }
+    if msg, err := _c2.TestRecv(time.Millisecond * 5); err != nil || !strings.HasSuffix(msg, u1 + " (edited): hello") {
+        t.Errorf("Invalid edit event: '%s' (%+v)", msg, err)
+    }
+    _c1.TestRecv(time.Millisecond * 5)
+
+    stored, _ := s.GetConf().Store.Range(cn, StoreQuery { Limit: 1 })
+    if len(stored) != 1 || stored[0].ID != id || stored[0].Message != "hello" || !stored[0].Edited {
+        t.Errorf("Stored message wasn't updated (or the edit event was stored): %+v", stored)
+    }
+
+    // The edited message replaces the original one in the history, so
+    // it's replayed exactly once.
+    c3 := NewMockConn()
+    _c3 := c3.(*mockConn)
+    c.ConnectUser(u3, c3)
+    var count int
+    for {
+        msg, err := _c3.TestRecv(time.Millisecond * 5)
+        if err != nil {
+            break
+        } else if strings.HasSuffix(msg, u1 + " (edited): hello") {
+            count++
+        }
+    }
+    if count != 1 {
+        t.Errorf("The edited message was replayed %d times", count)
+    }
+    _c1.TestRecv(time.Millisecond * 5)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    if err := c.DeleteMessage(id, u2); err != PermissionDenied {
+        t.Errorf("Invalid error for deleting another user's message! Expected '%+v' but got '%+v'", PermissionDenied, err)
+    }
+    c.SetRole(u2, RoleOperator)
+    if err := c.DeleteMessage(id, u2); err != nil {
+        t.Fatalf("Operator couldn't delete the message: %+v", err)
+    }
+    if msg, err := _c1.TestRecv(time.Millisecond * 5); err != nil || !strings.HasSuffix(msg, u2 + " deleted a message") {
+        t.Errorf("Invalid delete event: '%s' (%+v)", msg, err)
+    }
+    _c2.TestRecv(time.Millisecond * 5)
+
+    history = c.GetHistory(time.Time{}, 0)
+    for _, msg := range history {
+        if msg.ID == id && (!msg.Deleted || len(msg.Message) > 0) {
+            t.Errorf("Message wasn't deleted from the history: %+v", msg)
+        }
+    }
+    if err := c.EditMessage(id, u1, "undo"); err != MessageNotFound {
+        t.Errorf("Invalid error for editing a deleted message! Expected '%+v' but got '%+v'", MessageNotFound, err)
+    }
+
+    // Edits aren't executed as commands.
+    router := NewCommandRouter(nil)
+    edited := Message {
+        Kind: KindUser,
+        Message: "/quit",
+        From: u1,
+        Edited: true,
+    }
+    if got := router.EncodeMessage(c, &edited); !strings.HasSuffix(got, "/quit") {
+        t.Errorf("Edited message was executed as a command: '%s'", got)
+    }
+}
+
+// TestEditMessageLegacy check whether edited and deleted messages are
+// replayed as such through a `MessageEncoder`.
+func TestEditMessageLegacy(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.Controller = prefixEncoder {}
+    conf.HistoryReplay = conf.HistorySize
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c.ConnectUser(u1, c1)
+    _c1.TestRecv(time.Millisecond * 5)
+
+    for _, text := range []string { "helo", "oops" } {
+        c.NewBroadcast(text, u1)
+        _c1.TestRecv(time.Millisecond * 5)
+    }
+
+    history := c.GetHistory(time.Time{}, 2)
+    if len(history) != 2 {
+        t.Fatalf("Invalid history: %+v", history)
+    }
+    c.EditMessage(history[0].ID, u1, "hello")
+    _c1.TestRecv(time.Millisecond * 5)
+    c.DeleteMessage(history[1].ID, u1)
+    _c1.TestRecv(time.Millisecond * 5)
+
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+    c.ConnectUser(u2, c2)
+    var replay []string
+    for {
+        msg, err := _c2.TestRecv(time.Millisecond * 5)
+        if err != nil {
+            break
+        }
+        replay = append(replay, msg)
+    }
+
+    // Skip the first user joining, and the second one joining live.
+    if len(replay) != 4 {
+        t.Fatalf("Invalid replay: %+v", replay)
+    } else if want, got := "prefix: " + u1 + ": hello (edited)", replay[1]; want != got {
+        t.Errorf("Invalid edited message: expected '%s' but got '%s'", want, got)
+    } else if want, got := "prefix: : (deleted)", replay[2]; want != got {
+        t.Errorf("Invalid deleted message: expected '%s' but got '%s'", want, got)
+    }
+}
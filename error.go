@@ -24,6 +24,24 @@ const (
     TestTimeout
     // Invalid user.
     InvalidUser
+    // The user couldn't keep up with the messages sent by the channel.
+    SlowConsumer
+    // The user is banned from the channel.
+    UserBanned
+    // The user doesn't have the permission required by the operation.
+    PermissionDenied
+    // The user isn't allowed to connect to the channel.
+    UserNotAllowed
+    // The connection was vetoed by the server's `Authorizer`.
+    Unauthorized
+    // The passphrase doesn't match the channel's passphrase.
+    InvalidPassphrase
+    // The message was refused by a `Validator`.
+    InvalidMessage
+    // The message doesn't exist, or it's no longer kept by the channel.
+    MessageNotFound
+    // The reaction isn't a valid emoji.
+    InvalidReaction
 )
 
 func (c ChatError) Error() string {
@@ -46,6 +64,24 @@ func (c ChatError) Error() string {
         return "A test connection timed out"
     case InvalidUser:
         return "Invalid user"
+    case SlowConsumer:
+        return "The user couldn't keep up with the messages sent by the channel"
+    case UserBanned:
+        return "The user is banned from the channel"
+    case PermissionDenied:
+        return "The user doesn't have the permission required by the operation"
+    case UserNotAllowed:
+        return "The user isn't allowed to connect to the channel"
+    case Unauthorized:
+        return "The connection wasn't authorized"
+    case InvalidPassphrase:
+        return "Invalid passphrase"
+    case InvalidMessage:
+        return "Invalid message"
+    case MessageNotFound:
+        return "The message wasn't found"
+    case InvalidReaction:
+        return "Invalid reaction"
     default:
         return "Unknown error"
     }
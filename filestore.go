@@ -0,0 +1,165 @@
+package go_chat_i_guess
+
+import (
+    "bufio"
+    "encoding/hex"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+// fileStore keeps the messages broadcast by every channel in append-only
+// JSONL files, one per channel, within a directory.
+//
+// Updated messages are appended as update records, which are folded into
+// the message they update when the file is read.
+type fileStore struct {
+    // dir where the files are stored.
+    dir string
+
+    // lock synchronizes access to the files.
+    lock sync.Mutex
+}
+
+// fileRecord is a line in the file of a channel: either a message, or
+// an update to a message appended earlier.
+type fileRecord struct {
+    Message
+
+    // Update is set, instead of the message, on update records.
+    Update *Message `json:",omitempty"`
+}
+
+// path retrieve the path to the file of `channel`.
+//
+// The channel's name is hex-encoded, so any name may be safely used as a
+// file name.
+func (s *fileStore) path(channel string) string {
+    return filepath.Join(s.dir, hex.EncodeToString([]byte(channel)) + ".jsonl")
+}
+
+// Append `msg`, encoded as JSON, as a new line in the file of `channel`.
+func (s *fileStore) Append(channel string, msg Message) error {
+    return s.appendLine(channel, &msg, os.O_CREATE)
+}
+
+// appendLine encode `v` as JSON and append it as a new line in the file
+// of `channel`, opened with the extra `flags`.
+func (s *fileStore) appendLine(channel string, v interface{}, flags int) error {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    data = append(data, '\n')
+
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    f, err := os.OpenFile(s.path(channel), os.O_APPEND | os.O_WRONLY | flags, 0644)
+    if err != nil {
+        return err
+    }
+
+    _, err = f.Write(data)
+    if err != nil {
+        f.Close()
+        return err
+    }
+
+    return f.Close()
+}
+
+// Range read every message in the file of `channel`, retrieving those
+// selected by `query` with every update to them applied.
+func (s *fileStore) Range(channel string, query StoreQuery) ([]Message, error) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    f, err := os.Open(s.path(channel))
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var list []Message
+    // Maps the ID of each selected message to its index in `list`.
+    index := make(map[string]int)
+
+    scanner := bufio.NewScanner(f)
+    // Messages aren't limited in size, so let lines be arbitrarily long.
+    scanner.Buffer(nil, int(^uint(0) >> 1))
+    for scanner.Scan() {
+        var record fileRecord
+
+        err = json.Unmarshal(scanner.Bytes(), &record)
+        if err != nil {
+            return nil, err
+        }
+
+        if record.Update != nil {
+            // Updates don't change the sequence number nor the date, so
+            // messages that weren't selected stay unselected.
+            if i, ok := index[record.Update.ID]; ok {
+                list[i] = *record.Update
+            }
+        } else if query.match(&record.Message) {
+            if len(record.ID) > 0 {
+                index[record.ID] = len(list)
+            }
+            list = append(list, record.Message)
+        }
+    }
+    if err = scanner.Err(); err != nil {
+        return nil, err
+    }
+
+    return query.limit(list), nil
+}
+
+// Update append an update record for `msg` to the file of `channel`,
+// which replaces the message whose ID is `msg.ID` once the file is read.
+//
+// Checking whether the message was stored would require reading the
+// whole file, so updates to messages that were never stored are only
+// discarded when the file is read. Still, fails with `MessageNotFound` if
+// nothing was ever stored for `channel`.
+func (s *fileStore) Update(channel string, msg Message) error {
+    err := s.appendLine(channel, &struct { Update *Message } { &msg }, 0)
+    if os.IsNotExist(err) {
+        return MessageNotFound
+    }
+    return err
+}
+
+// DeleteChannel remove the file of `channel`.
+func (s *fileStore) DeleteChannel(channel string) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    err := os.Remove(s.path(channel))
+    if os.IsNotExist(err) {
+        return nil
+    }
+    return err
+}
+
+// NewFileStore create a new `MessageStore` that keeps the messages of
+// each channel in an append-only JSONL file within `dir`. The directory
+// is created, if it doesn't exist yet.
+//
+// Differently from `NewMemoryStore`, this store isn't bounded and survives
+// restarts. Updating a message appends a new line instead of rewriting
+// the file, so the file grows with every edit, deletion and reaction.
+func NewFileStore(dir string) (MessageStore, error) {
+    err := os.MkdirAll(dir, 0755)
+    if err != nil {
+        return nil, err
+    }
+
+    return &fileStore {
+        dir: dir,
+    }, nil
+}
@@ -24,6 +24,9 @@ type gwsConn struct {
     // The gorilla WebSocket connection.
     conn *gows.Conn
 
+    // remoteAddr is the address of the remote endpoint.
+    remoteAddr string
+
     // How long the connection waits until sending a ping back to the
     // remote endpoint.
     timeout time.Duration
@@ -66,6 +69,11 @@ func (c *gwsConn) Close() error {
     return nil
 }
 
+// RemoteAddr retrieve the address of the remote endpoint.
+func (c *gwsConn) RemoteAddr() string {
+    return c.remoteAddr
+}
+
 // resetTimeout reset the last timeout.
 //
 // This must be called whenever this connections receives any message from
@@ -215,6 +223,7 @@ func NewConn(upgrader gows.Upgrader, timeout time.Duration,
 
     c := &gwsConn {
         conn: conn,
+        remoteAddr: conn.RemoteAddr().String(),
         timeout: timeout,
         ticker: time.NewTicker(timeout),
         timeoutCount: 0,
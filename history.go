@@ -0,0 +1,192 @@
+package go_chat_i_guess
+
+import (
+    "sync"
+    "time"
+)
+
+// Default number of messages kept in a channel's history.
+const defHistorySize = 256
+
+// historyEntry associates a message to the string that was sent to the
+// channel's users, so it may be replayed without encoding it once again.
+type historyEntry struct {
+    // msg is the message, as it was received by the channel.
+    msg *Message
+
+    // encoded is the string that was broadcast to the channel's users.
+    encoded string
+}
+
+// history is a fixed-size ring buffer with the last messages broadcast
+// by a channel. Once the buffer is full, the oldest message gets
+// overwritten by the newest one.
+type history struct {
+    // entries in the ring buffer. Its length is the buffer's capacity.
+    entries []historyEntry
+
+    // start is the index of the oldest entry in the buffer.
+    start int
+
+    // count is the number of entries currently stored in the buffer.
+    count int
+
+    // lock synchronizes access to the buffer.
+    lock sync.Mutex
+}
+
+// newHistory create a new history that keeps, at most, the last `size`
+// messages. If `size` isn't positive, nil is returned and no history
+// shall be kept.
+func newHistory(size int) *history {
+    if size <= 0 {
+        return nil
+    }
+
+    return &history {
+        entries: make([]historyEntry, size),
+    }
+}
+
+// at retrieve the `i`-th oldest entry in the buffer, assuming that access
+// to the buffer is properly synchronized.
+func (h *history) at(i int) *historyEntry {
+    return &h.entries[(h.start + i) % len(h.entries)]
+}
+
+// push append a new message to the history, overwriting the oldest one
+// if the buffer is already full.
+func (h *history) push(msg *Message, encoded string) {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+
+    entry := historyEntry {
+        msg: msg,
+        encoded: encoded,
+    }
+
+    if h.count < len(h.entries) {
+        *h.at(h.count) = entry
+        h.count++
+    } else {
+        h.entries[h.start] = entry
+        h.start = (h.start + 1) % len(h.entries)
+    }
+}
+
+// find retrieve a copy of the message identified by `id`, and whether
+// it's in the history.
+func (h *history) find(id string) (Message, bool) {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+
+    for i := 0; i < h.count; i++ {
+        if entry := h.at(i); entry.msg.ID == id {
+            return *entry.msg, true
+        }
+    }
+
+    return Message {}, false
+}
+
+// update replace the message identified by `msg.ID` with `msg`, and its
+// encoded string with `encoded`, returning whether it was in the history.
+func (h *history) update(msg *Message, encoded string) bool {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+
+    for i := 0; i < h.count; i++ {
+        if entry := h.at(i); entry.msg.ID == msg.ID {
+            entry.msg = msg
+            entry.encoded = encoded
+            return true
+        }
+    }
+
+    return false
+}
+
+// thread retrieve a copy of the message identified by `id`, followed by
+// every reply to it, from the oldest to the newest, and whether the
+// message is in the history.
+func (h *history) thread(id string) ([]Message, bool) {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+
+    // Replies are always sent after the message they reply to, so
+    // anything before it may be skipped.
+    var list []Message
+    for i := 0; i < h.count; i++ {
+        msg := h.at(i).msg
+        if msg.ID == id || (len(list) > 0 && msg.ReplyTo == id) {
+            list = append(list, *msg)
+        }
+    }
+
+    return list, len(list) > 0
+}
+
+// last retrieve the encoded strings for the, at most, `n` newest
+// messages in the history, from the oldest to the newest.
+func (h *history) last(n int) []string {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+
+    if n > h.count {
+        n = h.count
+    }
+
+    var list []string
+    for i := h.count - n; i < h.count; i++ {
+        list = append(list, h.at(i).encoded)
+    }
+
+    return list
+}
+
+// after retrieve the encoded strings for every message in the history
+// whose sequence number is greater than `seq`, from the oldest to the
+// newest.
+func (h *history) after(seq uint64) []string {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+
+    var list []string
+    for i := 0; i < h.count; i++ {
+        if entry := h.at(i); entry.msg.Seq > seq {
+            list = append(list, entry.encoded)
+        }
+    }
+
+    return list
+}
+
+// since retrieve a copy of the, at most, `limit` newest messages that
+// were received after `since`, from the oldest to the newest.
+//
+// If `since` is the zero time, every message is considered. Similarly,
+// if `limit` isn't positive, every message is returned.
+func (h *history) since(since time.Time, limit int) []Message {
+    h.lock.Lock()
+    defer h.lock.Unlock()
+
+    // Messages are stored in the order they were received, so look for
+    // the first message after `since`.
+    first := 0
+    if !since.IsZero() {
+        for first < h.count && !h.at(first).msg.Date.After(since) {
+            first++
+        }
+    }
+
+    if limit > 0 && h.count - first > limit {
+        first = h.count - limit
+    }
+
+    var list []Message
+    for i := first; i < h.count; i++ {
+        list = append(list, *h.at(i).msg)
+    }
+
+    return list
+}
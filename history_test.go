@@ -0,0 +1,159 @@
+package go_chat_i_guess
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// TestHistory check whether the history is correctly bounded and
+// replayed to users joining a channel.
+func TestHistory(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.HistorySize = 3
+    conf.HistoryReplay = 2
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    err := s.CreateChannel(cn)
+    if err != nil {
+        t.Fatalf("Failed to create a channel: %+v", err)
+    }
+    c, err := s.GetChannel(cn)
+    if err != nil {
+        t.Fatalf("Couldn't retrieve the channel: %+v", err)
+    }
+
+    err = c.ConnectUser(u1, c1)
+    if err != nil {
+        t.Fatalf("Failed to connect %s to %s: %+v", u1, cn, err)
+    }
+    _, err = _c1.TestRecv(time.Millisecond * 5)
+    if err != nil {
+        t.Errorf("%s failed to detected that they joined %s: %+v", u1, cn, err)
+    }
+
+    input := []string {
+        "Twas brillig, and the slithy toves",
+        "Did gyre and gimble in the wabe:",
+        "All mimsy were the borogoves,",
+        "And the mome raths outgrabe.",
+    }
+    for _, in := range input {
+        err := _c1.TestSend(in)
+        if err != nil {
+            t.Errorf("Failed to send the message '%s': %+v", in, err)
+        }
+        _, err = _c1.TestRecv(time.Millisecond * 5)
+        if err != nil {
+            t.Errorf("%s failed to receive the message '%s': %+v", u1, in, err)
+        }
+    }
+
+    // Only the last 3 messages should be kept, since the history is
+    // bounded.
+    list := c.GetHistory(time.Time{}, 0)
+    if want, got := conf.HistorySize, len(list); want != got {
+        t.Fatalf("Invalid history length: expected '%d' but got '%d'", want, got)
+    }
+    for i, msg := range list {
+        if want, got := input[i + 1], msg.Message; want != got {
+            t.Errorf("Invalid message in the history: expected '%s' but got '%s'", want, got)
+        } else if want, got := u1, msg.From; want != got {
+            t.Errorf("Invalid sender in the history: expected '%s' but got '%s'", want, got)
+        }
+    }
+
+    list = c.GetHistory(time.Time{}, 1)
+    if want, got := 1, len(list); want != got {
+        t.Errorf("Invalid limited history length: expected '%d' but got '%d'", want, got)
+    } else if want, got := input[3], list[0].Message; want != got {
+        t.Errorf("Invalid message in the history: expected '%s' but got '%s'", want, got)
+    }
+
+    list = c.GetHistory(time.Now(), 0)
+    if want, got := 0, len(list); want != got {
+        t.Errorf("Invalid history length after now: expected '%d' but got '%d'", want, got)
+    }
+
+    // Check that the last messages are replayed before the join message.
+    err = c.ConnectUser(u2, c2)
+    if err != nil {
+        t.Fatalf("Failed to connect %s to %s: %+v", u2, cn, err)
+    }
+    for _, want := range append(input[2:], u2) {
+        msg, err := _c2.TestRecv(time.Millisecond * 5)
+        if err != nil {
+            t.Errorf("%s failed to receive the message '%s': %+v", u2, want, err)
+        } else if !strings.Contains(msg, want) {
+            t.Errorf("Message does not contain the expected text:\n\twant: %s\n\tgot: %s", want, msg)
+        }
+    }
+}
+
+// TestResume check whether a user resuming a connection receives every
+// message after the requested sequence number.
+func TestResume(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    err := c.ConnectUser(u1, c1)
+    if err != nil {
+        t.Fatalf("Failed to connect %s to %s: %+v", u1, cn, err)
+    }
+    _c1.TestRecv(time.Millisecond * 5)
+
+    input := []string { "one", "two", "three" }
+    for _, in := range input {
+        _c1.TestSend(in)
+        _c1.TestRecv(time.Millisecond * 5)
+    }
+
+    list := c.GetHistory(time.Time{}, len(input))
+    for i := 1; i < len(list); i++ {
+        if list[i - 1].Seq >= list[i].Seq {
+            t.Errorf("Sequence numbers aren't increasing: %d, %d", list[i - 1].Seq, list[i].Seq)
+        }
+    }
+
+    // Resume after the first message.
+    tk, err := s.RequestResumeToken(u2, cn, list[0].Seq)
+    if err != nil {
+        t.Fatalf("Failed to create a resume token: %+v", err)
+    }
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+    err = s.Connect(tk, c2)
+    if err != nil {
+        t.Fatalf("Failed to connect %s to %s: %+v", u2, cn, err)
+    }
+
+    for _, want := range append(input[1:], u2) {
+        msg, err := _c2.TestRecv(time.Millisecond * 5)
+        if err != nil {
+            t.Errorf("%s failed to receive the message '%s': %+v", u2, want, err)
+        } else if !strings.Contains(msg, want) {
+            t.Errorf("Message does not contain the expected text:\n\twant: %s\n\tgot: %s", want, msg)
+        }
+    }
+}
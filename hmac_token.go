@@ -0,0 +1,254 @@
+package go_chat_i_guess
+
+import (
+    "crypto/hmac"
+    crand "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "strings"
+    "sync"
+    "time"
+)
+
+// signedClaims is the payload of a token issued by a `hmacTokens`.
+type signedClaims struct {
+    // Username of the user that may connect with the token.
+    Username string `json:"u"`
+
+    // Channel that the token gives access to.
+    Channel string `json:"c"`
+
+    // Issued is when the token was issued, in Unix nanoseconds.
+    Issued int64 `json:"i"`
+
+    // Expires is when the token stops being accepted, in Unix nanoseconds.
+    Expires int64 `json:"e"`
+
+    // Uses is the number of times the token may be consumed.
+    Uses int `json:"x,omitempty"`
+
+    // Nonce uniquely identifies the token.
+    Nonce string `json:"n"`
+
+    // Options used when connecting to the channel.
+    Options ConnectOptions `json:"o"`
+
+    // Grant proves that the channel's passphrase was supplied.
+    Grant string `json:"g,omitempty"`
+}
+
+// hmacUse tracks how many times a token was consumed.
+type hmacUse struct {
+    // expires is when the token expires.
+    expires time.Time
+
+    // count is the number of times the token was consumed. If this is
+    // negative, the token was revoked.
+    count int
+}
+
+// hmacTokens is a `TokenProvider` that issues tokens signed with HMAC-SHA256.
+type hmacTokens struct {
+    // key used to sign the tokens.
+    key []byte
+
+    // used maps the nonce of every consumed (or revoked) token to its
+    // usage, so tokens may only be consumed as many times as allowed.
+    used map[string]hmacUse
+
+    // revoked maps users to when their tokens were revoked. Tokens issued
+    // to those users up to that time are rejected.
+    revoked map[string]time.Time
+
+    // lock synchronizes access to `used` and `revoked`.
+    lock sync.Mutex
+}
+
+// NewHMACTokenProvider create a `TokenProvider` that issues stateless
+// tokens, signed with HMAC-SHA256 using `key`.
+//
+// Each token carries its own claims, so any process sharing the same key
+// may verify it. To limit how many times each token may be used, the
+// provider remembers every token it consumed until it expires. Note that
+// this replay cache isn't shared between processes, so a load balancer
+// should route tokens to the same process (or the tokens should be
+// short-lived). The same applies to revoked tokens.
+//
+// Since tokens issued to a user can't be enumerated, `RevokeUser` rejects
+// every token issued to the user up to that moment. These revocations are
+// kept for as long as the provider exists.
+//
+// If `key` is empty, then this function will panic!
+func NewHMACTokenProvider(key []byte) TokenProvider {
+    if len(key) == 0 {
+        panic("go_chat_i_guess/token NewHMACTokenProvider: empty key")
+    }
+
+    return &hmacTokens {
+        key: append([]byte(nil), key...),
+        used: make(map[string]hmacUse),
+        revoked: make(map[string]time.Time),
+    }
+}
+
+// sign retrieve the signature of `payload`.
+func (h *hmacTokens) sign(payload string) []byte {
+    mac := hmac.New(sha256.New, h.key)
+    mac.Write([]byte(payload))
+    return mac.Sum(nil)
+}
+
+// Issue a new signed token granting `claims`.
+func (h *hmacTokens) Issue(claims TokenClaims) (string, error) {
+    var nonce [16]byte
+
+    _, err := crand.Read(nonce[:])
+    if err != nil {
+        return "", err
+    }
+
+    data, err := json.Marshal(&signedClaims {
+        Username: claims.Username,
+        Channel: claims.Channel,
+        Issued: claims.Issued.UnixNano(),
+        Expires: claims.Expires.UnixNano(),
+        Uses: claims.Uses,
+        Nonce: hex.EncodeToString(nonce[:]),
+        Options: claims.Options,
+        Grant: claims.Grant,
+    })
+    if err != nil {
+        return "", err
+    }
+
+    payload := base64.RawURLEncoding.EncodeToString(data)
+    sig := base64.RawURLEncoding.EncodeToString(h.sign(payload))
+
+    return payload + "." + sig, nil
+}
+
+// verify check the signature of `token`, retrieving its payload.
+func (h *hmacTokens) verify(token string) (signedClaims, error) {
+    var val signedClaims
+
+    idx := strings.LastIndexByte(token, '.')
+    if idx == -1 {
+        return val, InvalidToken
+    }
+    payload := token[:idx]
+
+    sig, err := base64.RawURLEncoding.DecodeString(token[idx+1:])
+    if err != nil || !hmac.Equal(sig, h.sign(payload)) {
+        return val, InvalidToken
+    }
+
+    data, err := base64.RawURLEncoding.DecodeString(payload)
+    if err != nil {
+        return val, InvalidToken
+    }
+    err = json.Unmarshal(data, &val)
+    if err != nil {
+        return val, InvalidToken
+    }
+
+    return val, nil
+}
+
+// Consume `token`, verifying its signature, its expiration and whether it
+// may still be used.
+func (h *hmacTokens) Consume(token string) (TokenClaims, error) {
+    val, err := h.verify(token)
+    if err != nil {
+        return TokenClaims {}, err
+    }
+
+    claims := TokenClaims {
+        Username: val.Username,
+        Channel: val.Channel,
+        Issued: time.Unix(0, val.Issued),
+        Expires: time.Unix(0, val.Expires),
+        Uses: val.Uses,
+        Options: val.Options,
+        Grant: val.Grant,
+    }
+    if claims.expired(time.Now()) {
+        return TokenClaims {}, InvalidToken
+    }
+
+    h.lock.Lock()
+    defer h.lock.Unlock()
+
+    if at, ok := h.revoked[claims.Username]; ok && !claims.Issued.After(at) {
+        return TokenClaims {}, InvalidToken
+    }
+
+    use, ok := h.used[val.Nonce]
+    if !ok {
+        use.expires = claims.Expires
+    } else if use.count < 0 {
+        return TokenClaims {}, InvalidToken
+    }
+
+    // Tokens without any explicit use may be consumed once, and tokens
+    // with negative uses may be consumed until they expire.
+    limit := claims.Uses
+    if limit == 0 {
+        limit = 1
+    }
+    if limit > 0 && use.count >= limit {
+        return TokenClaims {}, InvalidToken
+    }
+
+    // Report the uses left before consuming the token, just like the
+    // other providers.
+    if claims.Uses > 0 {
+        claims.Uses -= use.count
+    }
+
+    use.count++
+    h.used[val.Nonce] = use
+
+    return claims, nil
+}
+
+// Revoke `token`, remembering it until it expires.
+func (h *hmacTokens) Revoke(token string) error {
+    val, err := h.verify(token)
+    if err != nil {
+        return err
+    }
+
+    h.lock.Lock()
+    h.used[val.Nonce] = hmacUse {
+        expires: time.Unix(0, val.Expires),
+        count: -1,
+    }
+    h.lock.Unlock()
+
+    return nil
+}
+
+// RevokeUser reject every token issued to `username` up to now.
+func (h *hmacTokens) RevokeUser(username string) error {
+    h.lock.Lock()
+    h.revoked[username] = time.Now()
+    h.lock.Unlock()
+
+    return nil
+}
+
+// Sweep forget every consumed token that has expired by `now`, since
+// those would be rejected anyway.
+func (h *hmacTokens) Sweep(now time.Time) error {
+    h.lock.Lock()
+    for key, val := range h.used {
+        if now.After(val.expires) {
+            delete(h.used, key)
+        }
+    }
+    h.lock.Unlock()
+
+    return nil
+}
@@ -0,0 +1,290 @@
+package go_chat_i_guess
+
+import (
+    "encoding/json"
+    "strings"
+    "time"
+)
+
+// JSONEnvelope is the object sent to clients by the `JSONEncoder`. Its
+// `Type` identifies which other fields are set:
+//
+//  - "message": A message broadcast by `From`, with the text in `Text`
+//  - "system": A message broadcast by the system, with the text in `Text`
+//  - "whisper": A message sent to `To`, with the text in `Text`. `From`
+//    is only set for whispers sent by other users
+//  - "join": `User` joined the channel
+//  - "leave": `User` left the channel
+//  - "user_list": The users currently in the channel, listed in `Users`
+//  - "direct": A message sent by `From` to `To` through the server, with
+//    the text in `Text`. The sender may be in another channel
+//  - "topic": The channel's topic was changed to `Metadata["topic"]` by
+//    `Metadata["by"]`
+//  - "edit": The message `Target` was edited by `From`, with its new text
+//    in `Text`
+//  - "delete": The message `Target` was deleted by `From`
+//  - "reaction": `From` added (or removed, as reported by
+//    `Metadata["action"]`) the reaction `Metadata["emoji"]` to the message
+//    `Target`. Every reaction to the message is listed in `Reactions`
+//  - "thread": The message `Target` got a new reply, and now has
+//    `Replies` replies
+//
+// Messages replayed from the history are sent as they currently are, so
+// edited messages have `Edited` set, deleted messages have `Deleted` set
+// (and no text), messages that were reacted to list their reactions in
+// `Reactions` and messages that were replied to count their replies in
+// `Replies`.
+//
+// Replies are "message" envelopes with `ReplyTo` set to the ID of the
+// first message in their thread. The thread's messages may be retrieved
+// with `ChatChannel.GetThread`.
+type JSONEnvelope struct {
+    // Type of the envelope.
+    Type string `json:"type"`
+
+    // ID of the message that generated this envelope.
+    ID string `json:"id,omitempty"`
+
+    // Seq is the sequence number of the message that generated this
+    // envelope. Omitted for messages sent to a specific user and for
+    // events that update another message ("edit", "delete", "reaction"
+    // and "thread").
+    Seq uint64 `json:"seq,omitempty"`
+
+    // Date when the message was received by the server.
+    Date time.Time `json:"date"`
+
+    // Channel where the message was sent.
+    Channel string `json:"channel"`
+
+    // From whom the message was sent. Omitted for system messages.
+    From string `json:"from,omitempty"`
+
+    // To whom the message was sent. Omitted for broadcasts.
+    To string `json:"to,omitempty"`
+
+    // Text of the message.
+    Text string `json:"text,omitempty"`
+
+    // User that joined or left the channel.
+    User string `json:"user,omitempty"`
+
+    // Users currently in the channel.
+    Users []string `json:"users,omitempty"`
+
+    // Metadata associated with the message.
+    Metadata map[string]string `json:"metadata,omitempty"`
+
+    // Target is the ID of the message affected by this envelope.
+    Target string `json:"target,omitempty"`
+
+    // Edited reports that the message was edited.
+    Edited bool `json:"edited,omitempty"`
+
+    // Deleted reports that the message was deleted.
+    Deleted bool `json:"deleted,omitempty"`
+
+    // Reactions maps each emoji reacted to the message to the users that
+    // reacted with it.
+    Reactions map[string][]string `json:"reactions,omitempty"`
+
+    // ReplyTo is the ID of the message that this message replies to.
+    ReplyTo string `json:"reply_to,omitempty"`
+
+    // Replies is the number of replies to the message.
+    Replies int `json:"replies,omitempty"`
+}
+
+// JSONCommand is the object that clients send to the `JSONEncoder`. Its
+// `Type` identifies which other fields are used:
+//
+//  - "message": Broadcast `Text` to the channel
+//  - "whisper": Send `Text` privately to the user `To`
+//  - "users": Request the list of users in the channel
+//  - "edit": Replace the text of the message `ID` with `Text`
+//  - "delete": Delete the message `ID`
+//  - "react": React to the message `ID` with `Emoji`
+//  - "unreact": Remove the reaction `Emoji` from the message `ID`
+//  - "reply": Broadcast `Text` as a reply to the message `ID`
+//
+// Messages that aren't a JSON object are broadcast as plain text.
+type JSONCommand struct {
+    // Type of the command.
+    Type string `json:"type"`
+
+    // Text sent by the client.
+    Text string `json:"text,omitempty"`
+
+    // To whom a whisper should be sent.
+    To string `json:"to,omitempty"`
+
+    // ID of the message that should be edited, deleted, reacted or
+    // replied to.
+    ID string `json:"id,omitempty"`
+
+    // Emoji added to or removed from a message.
+    Emoji string `json:"emoji,omitempty"`
+}
+
+// JSONEncoder is a `ChannelController` that implements a machine-readable
+// protocol, encoding every message as a `JSONEnvelope` and parsing every
+// message received from clients as a `JSONCommand`.
+//
+// It implements both `MessageEncoder` and `MessageEncoderV2`, so it may
+// be set as either the `Controller` or the `Encoder` of a `ServerConf`.
+type JSONEncoder struct {}
+
+// OnConnect broadcasts a "join" envelope.
+func (JSONEncoder) OnConnect(channel ChatChannel, username string) {
+    channel.NewSystemEvent(KindJoin, username + " entered " + channel.Name() + "!",
+            "", map[string]string { "user": username })
+}
+
+// OnDisconnect broadcasts a "leave" envelope.
+func (JSONEncoder) OnDisconnect(channel RestrictedChatChannel,
+        username string) {
+    channel.NewSystemEvent(KindLeave, username + " exited " + channel.Name() + "...",
+            "", map[string]string { "user": username })
+}
+
+// Encode the message, as received by a `MessageEncoder`, into a
+// `JSONEnvelope`.
+func (e JSONEncoder) Encode(channel ChatChannel, date time.Time, msg, from,
+        to string) string {
+
+    kind := KindUser
+    if len(to) > 0 {
+        kind = KindWhisper
+    } else if len(from) == 0 {
+        kind = KindSystem
+    }
+
+    return e.EncodeMessage(channel, &Message {
+        Date: date,
+        Message: msg,
+        From: from,
+        To: to,
+        Kind: kind,
+    })
+}
+
+// EncodeMessage encode `msg` into a `JSONEnvelope`.
+//
+// Messages sent by users are first parsed as a `JSONCommand`. Commands
+// that don't generate a broadcast are processed and filtered out. On
+// the other hand, `msg.Message` is replaced by the command's text, so
+// only the text gets logged by the channel.
+func (e JSONEncoder) EncodeMessage(channel ChatChannel, msg *Message) string {
+    if msg.Kind == KindUser && !msg.revised() && !e.parseCommand(channel, msg) {
+        return ""
+    }
+
+    env := JSONEnvelope {
+        ID: msg.ID,
+        Seq: msg.Seq,
+        Date: msg.Date,
+        Channel: channel.Name(),
+        From: msg.From,
+        To: msg.To,
+        Text: msg.Message,
+        Metadata: msg.Metadata,
+        Edited: msg.Edited,
+        Deleted: msg.Deleted,
+        Reactions: msg.Reactions,
+        ReplyTo: msg.ReplyTo,
+        Replies: msg.Replies,
+    }
+
+    switch msg.Kind {
+    case KindUser:
+        env.Type = "message"
+    case KindSystem:
+        env.Type = "system"
+    case KindWhisper:
+        env.Type = "whisper"
+    case KindJoin:
+        env.Type = "join"
+        env.User = msg.Metadata["user"]
+    case KindLeave:
+        env.Type = "leave"
+        env.User = msg.Metadata["user"]
+    case KindUserList:
+        env.Type = "user_list"
+        env.Users = channel.GetUsers(nil)
+    case KindEdit, KindDelete, KindReaction, KindThread:
+        env.Type = msg.Kind.String()
+        env.Target = msg.Metadata["id"]
+    default:
+        env.Type = msg.Kind.String()
+    }
+
+    data, err := json.Marshal(&env)
+    if err != nil {
+        return ""
+    }
+    return string(data)
+}
+
+// parseCommand parse the `JSONCommand` in `msg`, returning whether the
+// message should be broadcast.
+func (JSONEncoder) parseCommand(channel ChatChannel, msg *Message) bool {
+    // Anything that isn't an object is considered to be plain text.
+    if !strings.HasPrefix(strings.TrimSpace(msg.Message), "{") {
+        return true
+    }
+
+    var cmd JSONCommand
+    err := json.Unmarshal([]byte(msg.Message), &cmd)
+    if err != nil {
+        channel.NewSystemWhisper("Invalid command: " + err.Error(), msg.From)
+        return false
+    }
+
+    switch cmd.Type {
+    case "message":
+        text, err := channel.Validate(cmd.Text)
+        if err != nil {
+            channel.NewSystemWhisper("Couldn't send the message: " + err.Error(), msg.From)
+            return false
+        }
+        msg.Message = text
+        return true
+    case "whisper":
+        if len(cmd.To) == 0 {
+            channel.NewSystemWhisper("Missing the whisper's receiver", msg.From)
+        } else {
+            channel.NewWhisper(cmd.Text, msg.From, cmd.To)
+        }
+    case "users":
+        channel.NewSystemEvent(KindUserList, "", msg.From, nil)
+    case "edit":
+        err := channel.EditMessage(cmd.ID, msg.From, cmd.Text)
+        if err != nil {
+            channel.NewSystemWhisper("Couldn't edit the message: " + err.Error(), msg.From)
+        }
+    case "delete":
+        err := channel.DeleteMessage(cmd.ID, msg.From)
+        if err != nil {
+            channel.NewSystemWhisper("Couldn't delete the message: " + err.Error(), msg.From)
+        }
+    case "react":
+        err := channel.AddReaction(cmd.ID, msg.From, cmd.Emoji)
+        if err != nil {
+            channel.NewSystemWhisper("Couldn't react to the message: " + err.Error(), msg.From)
+        }
+    case "unreact":
+        err := channel.RemoveReaction(cmd.ID, msg.From, cmd.Emoji)
+        if err != nil {
+            channel.NewSystemWhisper("Couldn't remove the reaction: " + err.Error(), msg.From)
+        }
+    case "reply":
+        err := channel.NewReply(cmd.Text, msg.From, cmd.ID)
+        if err != nil {
+            channel.NewSystemWhisper("Couldn't reply to the message: " + err.Error(), msg.From)
+        }
+    default:
+        channel.NewSystemWhisper("Unknown command: " + cmd.Type, msg.From)
+    }
+
+    return false
+}
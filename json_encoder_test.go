@@ -0,0 +1,130 @@
+package go_chat_i_guess
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+    "time"
+)
+
+// recvEnvelope wait for the next `JSONEnvelope` sent to `conn`.
+func recvEnvelope(t *testing.T, conn *mockConn) JSONEnvelope {
+    var env JSONEnvelope
+
+    msg, err := conn.TestRecv(time.Millisecond * 5)
+    if err != nil {
+        t.Fatalf("Failed to receive a message: %+v", err)
+    }
+
+    err = json.Unmarshal([]byte(msg), &env)
+    if err != nil {
+        t.Fatalf("Failed to decode '%s': %+v", msg, err)
+    }
+
+    return env
+}
+
+// TestJSONEncoder check whether the JSON protocol is correctly encoded
+// and parsed.
+func TestJSONEncoder(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.Controller = JSONEncoder {}
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    err := c.ConnectUser(u1, c1)
+    if err != nil {
+        t.Fatalf("Failed to connect %s to %s: %+v", u1, cn, err)
+    }
+
+    env := recvEnvelope(t, _c1)
+    if want, got := "join", env.Type; want != got {
+        t.Errorf("Invalid envelope type: expected '%s' but got '%s'", want, got)
+    } else if want, got := u1, env.User; want != got {
+        t.Errorf("Invalid user joined: expected '%s' but got '%s'", want, got)
+    } else if want, got := cn, env.Channel; want != got {
+        t.Errorf("Invalid channel: expected '%s' but got '%s'", want, got)
+    }
+
+    // Both commands and plain text should be broadcast as messages.
+    for _, in := range []string { `{"type": "message", "text": "hi"}`, "hi" } {
+        _c1.TestSend(in)
+        env = recvEnvelope(t, _c1)
+        if want, got := "message", env.Type; want != got {
+            t.Errorf("Invalid envelope type: expected '%s' but got '%s'", want, got)
+        } else if want, got := "hi", env.Text; want != got {
+            t.Errorf("Invalid text: expected '%s' but got '%s'", want, got)
+        } else if want, got := u1, env.From; want != got {
+            t.Errorf("Invalid sender: expected '%s' but got '%s'", want, got)
+        } else if env.Seq == 0 || len(env.ID) == 0 {
+            t.Errorf("Message doesn't have an ID or a sequence number: %+v", env)
+        }
+    }
+    if list := c.GetHistory(time.Time{}, 1); len(list) != 1 || list[0].Message != "hi" {
+        t.Errorf("The command was logged instead of its text: %+v", list)
+    }
+
+    _c1.TestSend(`{"type": "users"}`)
+    env = recvEnvelope(t, _c1)
+    if want, got := "user_list", env.Type; want != got {
+        t.Errorf("Invalid envelope type: expected '%s' but got '%s'", want, got)
+    } else if len(env.Users) != 1 || env.Users[0] != u1 {
+        t.Errorf("Invalid user list: %+v", env.Users)
+    }
+
+    _c1.TestSend(`{"type": "dance"}`)
+    env = recvEnvelope(t, _c1)
+    if want, got := "whisper", env.Type; want != got {
+        t.Errorf("Invalid envelope type: expected '%s' but got '%s'", want, got)
+    } else if want, got := u1, env.To; want != got {
+        t.Errorf("Invalid receiver: expected '%s' but got '%s'", want, got)
+    }
+}
+
+// TestJSONEncoderSaturated check whether JSON commands may queue messages
+// while the channel's queue is full.
+func TestJSONEncoderSaturated(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+
+    for _, test := range []struct {
+        cmd string
+        want string
+    } {
+        { "{bad", "Invalid command" },
+        { `{"type": "whisper", "to": "user1", "text": "psst"}`, "psst" },
+        { `{"type": "reply", "id": "%s", "text": "answer"}`, "answer" },
+    } {
+        gate := make(chan struct{})
+
+        conf := GetDefaultServerConf()
+        conf.Encoder = gatedEncoder { JSONEncoder {}, gate }
+        s := NewServerConf(conf)
+
+        s.CreateChannel(cn)
+        c, _ := s.GetChannel(cn)
+
+        c1 := NewMockConn()
+        _c1 := c1.(*mockConn)
+        c.ConnectUser(u1, c1)
+        recvEnvelope(t, _c1)
+
+        _c1.TestSend("hello")
+        parent := recvEnvelope(t, _c1)
+
+        sendSaturated(c, gate, u1, strings.Replace(test.cmd, "%s", parent.ID, 1))
+        if _, ok := recvContaining(_c1, test.want); !ok {
+            t.Errorf("The channel stalled while handling '%s'", test.cmd)
+        }
+
+        s.Close()
+    }
+}
@@ -0,0 +1,353 @@
+package go_chat_i_guess
+
+import (
+    crand "crypto/rand"
+    "encoding/hex"
+    "hash/crc32"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// MessageKind identifies what a message represents.
+type MessageKind uint
+
+const (
+    // A message broadcast by a user.
+    KindUser MessageKind = iota
+    // A message broadcast by the system (i.e., without a sender).
+    KindSystem
+    // A message sent to a specific user.
+    KindWhisper
+    // A user joined the channel. The user is stored in the message's
+    // `Metadata["user"]`.
+    KindJoin
+    // A user left the channel. The user is stored in the message's
+    // `Metadata["user"]`.
+    KindLeave
+    // The list of users in the channel was requested.
+    KindUserList
+    // A private message sent to a specific user through the server, by a
+    // sender that may not be in the channel.
+    KindDirect
+    // The channel's topic was changed. The new topic is stored in the
+    // message's `Metadata["topic"]`, and the user that changed it (if
+    // any) in `Metadata["by"]`.
+    KindTopic
+    // A message was edited by its sender. The new text is stored in the
+    // message itself, and the ID of the edited message in
+    // `Metadata["id"]`.
+    KindEdit
+    // A message was deleted by the user in `From`. The ID of the deleted
+    // message is stored in `Metadata["id"]`.
+    KindDelete
+    // The reactions to a message changed. The ID of the message is stored
+    // in `Metadata["id"]`, the user that reacted in `From`, the emoji in
+    // `Metadata["emoji"]` and whether it was "add"ed or "remove"d in
+    // `Metadata["action"]`. Every reaction to the message is stored in
+    // `Reactions`.
+    KindReaction
+    // A message got a new reply. The ID of the message is stored in
+    // `Metadata["id"]`, and its number of replies in `Replies`.
+    KindThread
+)
+
+func (k MessageKind) String() string {
+    switch k {
+    case KindUser:
+        return "user"
+    case KindSystem:
+        return "system"
+    case KindWhisper:
+        return "whisper"
+    case KindJoin:
+        return "join"
+    case KindLeave:
+        return "leave"
+    case KindUserList:
+        return "user_list"
+    case KindDirect:
+        return "direct"
+    case KindTopic:
+        return "topic"
+    case KindEdit:
+        return "edit"
+    case KindDelete:
+        return "delete"
+    case KindReaction:
+        return "reaction"
+    case KindThread:
+        return "thread"
+    default:
+        return "unknown"
+    }
+}
+
+// Message represent a message received by the server, alongside its
+// metadata.
+type Message struct {
+    // ID uniquely identifies this message.
+    ID string
+
+    // Seq is the sequence number of a broadcast within its channel.
+    // Sequence numbers increase monotonically, but may skip some values
+    // (for example, for messages filtered out by the encoder). This is
+    // zero for messages sent to a specific user, and for events that
+    // update another message (like edits and reactions).
+    Seq uint64
+
+    // Date when the message was received by the server.
+    Date time.Time
+
+    // Message received by the server.
+    Message string
+
+    // From whom the message was sent. Empty for automatic/system messages.
+    From string
+
+    // To whom the message will be sent. Empty for broadcasts and
+    // omitted when encoded into JSON.
+    To string `json:"-"`
+
+    // Kind of the message.
+    Kind MessageKind
+
+    // Metadata optionally associated with the message by the
+    // application. May be nil.
+    Metadata map[string]string `json:",omitempty"`
+
+    // Edited reports that the message's text was edited by its sender.
+    Edited bool `json:",omitempty"`
+
+    // Deleted reports that the message was deleted, in which case its
+    // text is empty.
+    Deleted bool `json:",omitempty"`
+
+    // Reactions maps each emoji reacted to the message to the users that
+    // reacted with it, sorted by name. For `KindReaction` events, these
+    // are the reactions to the message that was reacted to.
+    Reactions map[string][]string `json:",omitempty"`
+
+    // ReplyTo is the ID of the message that this message replies to, if
+    // it was sent to a thread. The author of that message is stored in
+    // `Metadata["parent_from"]`.
+    ReplyTo string `json:",omitempty"`
+
+    // Replies is the number of replies to this message, including the
+    // deleted ones. For `KindThread` events, this is the number of
+    // replies to the message that got a new reply.
+    Replies int `json:",omitempty"`
+
+    // encoded reports that the message was already encoded once.
+    encoded bool
+}
+
+// revised check whether the message is being encoded once again (for
+// example, because it was edited, reacted to or loaded from the store),
+// in which case it mustn't be processed as a command once again.
+func (m *Message) revised() bool {
+    return m.Edited || m.Deleted || m.encoded
+}
+
+// updates check whether the message is an event that only updates
+// another message, in which case it isn't logged by the channel, as the
+// updated message already gets replaced in the channel's history.
+func (m *Message) updates() bool {
+    switch m.Kind {
+    case KindEdit, KindDelete, KindReaction, KindThread:
+        return true
+    default:
+        return false
+    }
+}
+
+// replyCount format the number of replies to the message as " (N
+// replies)".
+func (m *Message) replyCount() string {
+    if m.Replies == 1 {
+        return " (1 reply)"
+    } else if m.Replies > 1 {
+        return " (" + strconv.Itoa(m.Replies) + " replies)"
+    }
+    return ""
+}
+
+// reactionList format the reactions to the message as "[emoji count,
+// ...]", sorted by emoji.
+func (m *Message) reactionList() string {
+    if len(m.Reactions) == 0 {
+        return ""
+    }
+
+    var list []string
+    for emoji, users := range m.Reactions {
+        list = append(list, emoji + " " + strconv.Itoa(len(users)))
+    }
+    sort.Strings(list)
+
+    return " [" + strings.Join(list, ", ") + "]"
+}
+
+// newMessageID generate a random identifier for a message.
+func newMessageID() (string, error) {
+    var id [12]byte
+
+    _, err := crand.Read(id[:])
+    if err != nil {
+        return "", err
+    }
+
+    return hex.EncodeToString(id[:]), nil
+}
+
+// Encode the message into a string that may be sent to users.
+func (m *Message) Encode() string {
+    t := m.Date.Format("2006-01-02 - 15:04:05 (-0700)")
+    u := ""
+    if m.Kind == KindThread {
+        // Replies are already shown as such, and updated reply counts are
+        // shown whenever the message is replayed.
+        return ""
+    } else if m.Kind == KindReaction {
+        return t + " > " + m.Message + m.reactionList()
+    } else if m.Kind == KindDelete {
+        return t + " > " + m.From + " deleted a message"
+    } else if m.Deleted {
+        return t + " > (deleted)" + m.replyCount()
+    } else if m.Edited || m.Kind == KindEdit || len(m.ReplyTo) > 0 {
+        var notes []string
+        if m.Edited || m.Kind == KindEdit {
+            notes = append(notes, "edited")
+        }
+        if parent := m.Metadata["parent_from"]; len(parent) > 0 {
+            notes = append(notes, "reply to " + parent)
+        } else if len(m.ReplyTo) > 0 {
+            notes = append(notes, "reply")
+        }
+        u = m.From + " (" + strings.Join(notes, ", ") + "): "
+    } else if len(m.From) > 0 && len(m.To) > 0 {
+        u = m.From + " -> " + m.To + ": "
+    } else if len(m.From) > 0 {
+        u = m.From + ": "
+    }
+    return t + " > " + u + m.Message + m.reactionList() + m.replyCount()
+}
+
+// getUID generate a unique identifier for the message.
+//
+// This should only be used for debugging purposes, as performance isn't
+// the primary concern of this function.
+func (m *Message) getUID() string {
+    hasher := crc32.NewIEEE()
+
+    date, _ := m.Date.MarshalBinary()
+    hasher.Write(date)
+    hasher.Write([]byte(m.From))
+    hasher.Write([]byte(m.To))
+    hasher.Write([]byte(m.Message))
+
+    uid := hasher.Sum(nil)
+    return hex.EncodeToString(uid)
+}
+
+// MessageEncoder encodes a given message into the string that will be
+// sent by the channel.
+//
+// New code should prefer implementing `MessageEncoderV2`, which receives
+// every field of the `Message`.
+type MessageEncoder interface {
+    // Encode the message described in the parameter into the string that
+    // will be sent to the channel.
+    //
+    // Returning the empty string will cancel sending the message, which
+    // may be useful for command processing. It may also be used to reply
+    // directly, and exclusively, to the sender after processing the
+    // message.
+    //
+    // `from` is set internally by the `ChatChannel`, based on the `Conn`
+    // that received this message and forwarded it to the server. Using it
+    // to determine whether the requesting user is allowed to do some
+    // operation should be safe, as long as users are properly
+    // authenticated before generating their connection token.
+    Encode(channel ChatChannel, date time.Time, msg, from, to string) string
+}
+
+// MessageEncoderV2 encodes a given message into the string that will be
+// sent by the channel.
+type MessageEncoderV2 interface {
+    // EncodeMessage encode `msg` into the string that will be sent to the
+    // channel.
+    //
+    // Returning the empty string will cancel sending the message, which
+    // may be useful for command processing. It may also be used to reply
+    // directly, and exclusively, to the sender after processing the
+    // message.
+    //
+    // The encoder may modify `msg` (for example, to normalize its text or
+    // to add some metadata), as it's only logged after being encoded.
+    //
+    // The encoder is called from the channel's goroutine. Messages queued
+    // through `channel` (for example, a reply to a command) don't wait
+    // for the channel's queue to have room, and are handled right after
+    // `msg`.
+    //
+    // `msg.From` is set internally by the `ChatChannel`, based on the
+    // `Conn` that received this message and forwarded it to the server.
+    // Using it to determine whether the requesting user is allowed to do
+    // some operation should be safe, as long as users are properly
+    // authenticated before generating their connection token.
+    EncodeMessage(channel ChatChannel, msg *Message) string
+}
+
+// encoderAdapter uses a `MessageEncoder` as a `MessageEncoderV2`.
+type encoderAdapter struct {
+    MessageEncoder
+}
+
+// EncodeMessage encode `msg` using the adapted `MessageEncoder`.
+//
+// `KindThread` events have no text, so they're filtered out. Edits,
+// deletions and reactions would look like new messages from their user,
+// so they're encoded as system messages describing the change.
+//
+// Messages that were changed are encoded like in `Message.Encode()`:
+// deleted messages are encoded as the system message "(deleted)", and
+// edited messages have " (edited)" appended to their text. Similarly,
+// their reactions and their number of replies are appended to their text.
+func (a encoderAdapter) EncodeMessage(channel ChatChannel, msg *Message) string {
+    switch msg.Kind {
+    case KindThread:
+        return ""
+    case KindEdit:
+        return a.Encode(channel, msg.Date, msg.From + " edited a message: " + msg.Message, "", "")
+    case KindDelete:
+        return a.Encode(channel, msg.Date, msg.From + " deleted a message", "", "")
+    case KindReaction:
+        return a.Encode(channel, msg.Date, msg.Message + msg.reactionList(), "", "")
+    }
+    if msg.Deleted {
+        return a.Encode(channel, msg.Date, "(deleted)" + msg.replyCount(), "", "")
+    }
+
+    text := msg.Message
+    if msg.Edited {
+        text += " (edited)"
+    }
+    text += msg.reactionList() + msg.replyCount()
+    return a.Encode(channel, msg.Date, text, msg.From, msg.To)
+}
+
+// AdaptEncoder wraps `enc` so it may be used as a `MessageEncoderV2`.
+//
+// If `enc` is nil, nil is returned. If `enc` already implements
+// `MessageEncoderV2`, it's returned as is.
+func AdaptEncoder(enc MessageEncoder) MessageEncoderV2 {
+    if enc == nil {
+        return nil
+    } else if v2, ok := enc.(MessageEncoderV2); ok {
+        return v2
+    }
+
+    return encoderAdapter { enc }
+}
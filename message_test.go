@@ -0,0 +1,114 @@
+package go_chat_i_guess
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// upperEncoder is a `MessageEncoderV2` that converts messages to upper
+// case and tags them with their kind.
+type upperEncoder struct {}
+
+func (upperEncoder) EncodeMessage(channel ChatChannel, msg *Message) string {
+    msg.Message = strings.ToUpper(msg.Message)
+    msg.Metadata = map[string]string { "kind": msg.Kind.String() }
+    return msg.Kind.String() + " " + msg.Message
+}
+
+// prefixEncoder is a `MessageEncoder` that prefixes messages.
+type prefixEncoder struct {}
+
+func (prefixEncoder) Encode(channel ChatChannel, date time.Time, msg, from,
+        to string) string {
+    return "prefix: " + from + ": " + msg
+}
+
+// TestMessageEncoderV2 check whether the channel encodes messages using
+// both versions of the encoder.
+func TestMessageEncoderV2(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+
+    tests := []struct {
+        conf func(*ServerConf)
+        want string
+    } {
+        { func(c *ServerConf) { c.Encoder = upperEncoder {} }, "user HELLO" },
+        { func(c *ServerConf) { c.Controller = prefixEncoder {} }, "prefix: user1: hello" },
+    }
+
+    for _, test := range tests {
+        conf := GetDefaultServerConf()
+        test.conf(&conf)
+        s := NewServerConf(conf)
+
+        s.CreateChannel(cn)
+        c, _ := s.GetChannel(cn)
+
+        c1 := NewMockConn()
+        _c1 := c1.(*mockConn)
+        err := c.ConnectUser(u1, c1)
+        if err != nil {
+            t.Fatalf("Failed to connect %s to %s: %+v", u1, cn, err)
+        }
+        _c1.TestRecv(time.Millisecond * 5)
+
+        _c1.TestSend("hello")
+        msg, err := _c1.TestRecv(time.Millisecond * 5)
+        if err != nil {
+            t.Errorf("%s failed to receive the message: %+v", u1, err)
+        } else if want, got := test.want, msg; want != got {
+            t.Errorf("Invalid encoded message: expected '%s' but got '%s'", want, got)
+        }
+
+        list := c.GetHistory(time.Time{}, 1)
+        if len(list) != 1 {
+            t.Errorf("Message wasn't logged")
+        } else if want, got := KindUser, list[0].Kind; want != got {
+            t.Errorf("Invalid message kind: expected '%s' but got '%s'", want, got)
+        } else if len(list[0].ID) == 0 {
+            t.Errorf("Message doesn't have an ID")
+        }
+
+        s.Close()
+    }
+}
+
+// TestEncoderAdapter check whether events that update other messages are
+// encoded as system messages by an adapted `MessageEncoder`, and whether
+// messages are encoded with their reactions and replies.
+func TestEncoderAdapter(t *testing.T) {
+    enc := AdaptEncoder(prefixEncoder {})
+
+    tests := []struct {
+        msg Message
+        want string
+    } {
+        { Message { Kind: KindUser, Message: "hello", From: "user1" }, "prefix: user1: hello" },
+        { Message { Kind: KindEdit, Message: "hi", From: "user1" }, "prefix: : user1 edited a message: hi" },
+        { Message { Kind: KindDelete, From: "user2" }, "prefix: : user2 deleted a message" },
+        { Message {
+            Kind: KindReaction,
+            Message: "user2 reacted with 👍",
+            From: "user2",
+            Reactions: map[string][]string { "👍": []string { "user2" } },
+        }, "prefix: : user2 reacted with 👍 [👍 1]" },
+        { Message { Kind: KindThread, Replies: 1 }, "" },
+        { Message {
+            Kind: KindUser,
+            Message: "hello",
+            From: "user1",
+            Reactions: map[string][]string { "🎉": []string { "user1", "user2" } },
+            Replies: 2,
+        }, "prefix: user1: hello [🎉 2] (2 replies)" },
+        { Message { Kind: KindUser, Message: "hi", From: "user1", Edited: true }, "prefix: user1: hi (edited)" },
+        { Message { Kind: KindUser, From: "user1", Deleted: true, Replies: 1 }, "prefix: : (deleted) (1 reply)" },
+    }
+
+    for _, test := range tests {
+        if want, got := test.want, enc.EncodeMessage(nil, &test.msg); want != got {
+            t.Errorf("Invalid encoded %s message: expected '%s' but got '%s'", test.msg.Kind, want, got)
+        }
+    }
+}
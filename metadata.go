@@ -0,0 +1,212 @@
+package go_chat_i_guess
+
+import (
+    "sync"
+    "time"
+)
+
+// ChannelInfo is a snapshot of a channel's metadata.
+type ChannelInfo struct {
+    // Name of the channel.
+    Name string
+
+    // Topic currently being discussed in the channel.
+    Topic string
+
+    // Description of the channel.
+    Description string
+
+    // Creator of the channel. Empty if it was created by the application.
+    Creator string
+
+    // Created is when the channel was created.
+    Created time.Time
+
+    // Attributes are free-form values associated with the channel.
+    Attributes map[string]string
+}
+
+// ChannelSummary is a snapshot of a channel, as listed by
+// `ChatServer.ListChannels`.
+type ChannelSummary struct {
+    ChannelInfo
+
+    // Users is the number of users connected to the channel.
+    Users int
+
+    // LastActivity is when the last message was broadcast in the channel,
+    // or when the last user connected to it.
+    LastActivity time.Time
+
+    // Private reports whether only some users may connect to the channel.
+    Private bool
+}
+
+// metadata describes a channel.
+type metadata struct {
+    // topic currently being discussed in the channel.
+    topic string
+
+    // description of the channel.
+    description string
+
+    // creator of the channel.
+    creator string
+
+    // created is when the channel was created.
+    created time.Time
+
+    // lastActivity is when the last message was broadcast in the channel,
+    // or when the last user connected to it.
+    lastActivity time.Time
+
+    // attributes are free-form values associated with the channel.
+    attributes map[string]string
+
+    // lock synchronizes access to the metadata.
+    lock sync.Mutex
+}
+
+// Topic retrieve the topic currently being discussed in this channel.
+func (c *channel) Topic() string {
+    c.meta.lock.Lock()
+    defer c.meta.lock.Unlock()
+
+    return c.meta.topic
+}
+
+// SetTopic change the topic of this channel to `topic`, broadcasting the
+// change to every user as a `KindTopic` event. `by` is the user that
+// changed the topic, and may be empty if it was changed by the
+// application.
+func (c *channel) SetTopic(topic, by string) {
+    c.queue(c.setTopic(topic, by))
+}
+
+// setTopic change the topic of this channel to `topic`, retrieving the
+// `KindTopic` event that reports the change.
+func (c *channel) setTopic(topic, by string) *Message {
+    c.meta.lock.Lock()
+    c.meta.topic = topic
+    c.meta.lock.Unlock()
+
+    meta := map[string]string {
+        "topic": topic,
+    }
+    if len(by) > 0 {
+        meta["by"] = by
+        return c.makeMessage(KindTopic, by + " changed the topic to: " + topic, "", "", meta)
+    }
+    return c.makeMessage(KindTopic, "The topic was changed to: " + topic, "", "", meta)
+}
+
+// Description retrieve the description of this channel.
+func (c *channel) Description() string {
+    c.meta.lock.Lock()
+    defer c.meta.lock.Unlock()
+
+    return c.meta.description
+}
+
+// SetDescription change the description of this channel.
+func (c *channel) SetDescription(description string) {
+    c.meta.lock.Lock()
+    c.meta.description = description
+    c.meta.lock.Unlock()
+}
+
+// Creator retrieve the user that created this channel. This is empty if
+// the channel was created by the application.
+func (c *channel) Creator() string {
+    c.meta.lock.Lock()
+    defer c.meta.lock.Unlock()
+
+    return c.meta.creator
+}
+
+// Created retrieve when this channel was created.
+func (c *channel) Created() time.Time {
+    c.meta.lock.Lock()
+    defer c.meta.lock.Unlock()
+
+    return c.meta.created
+}
+
+// GetAttribute retrieve the attribute `key` of this channel, and whether
+// it was set.
+func (c *channel) GetAttribute(key string) (string, bool) {
+    c.meta.lock.Lock()
+    defer c.meta.lock.Unlock()
+
+    val, ok := c.meta.attributes[key]
+    return val, ok
+}
+
+// SetAttribute set the attribute `key` of this channel to `value`.
+func (c *channel) SetAttribute(key, value string) {
+    c.meta.lock.Lock()
+    if c.meta.attributes == nil {
+        c.meta.attributes = make(map[string]string)
+    }
+    c.meta.attributes[key] = value
+    c.meta.lock.Unlock()
+}
+
+// RemoveAttribute remove the attribute `key` from this channel.
+func (c *channel) RemoveAttribute(key string) {
+    c.meta.lock.Lock()
+    delete(c.meta.attributes, key)
+    c.meta.lock.Unlock()
+}
+
+// Info retrieve a snapshot of this channel's metadata.
+func (c *channel) Info() ChannelInfo {
+    c.meta.lock.Lock()
+    defer c.meta.lock.Unlock()
+
+    return ChannelInfo {
+        Name: c.name,
+        Topic: c.meta.topic,
+        Description: c.meta.description,
+        Creator: c.meta.creator,
+        Created: c.meta.created,
+        Attributes: copyMap(c.meta.attributes),
+    }
+}
+
+// touch set the channel's last activity to `now`.
+func (c *channel) touch(now time.Time) {
+    c.meta.lock.Lock()
+    if now.After(c.meta.lastActivity) {
+        c.meta.lastActivity = now
+    }
+    c.meta.lock.Unlock()
+}
+
+// LastActivity retrieve when the last message was broadcast in this
+// channel, or when the last user connected to it.
+func (c *channel) LastActivity() time.Time {
+    c.meta.lock.Lock()
+    defer c.meta.lock.Unlock()
+
+    return c.meta.lastActivity
+}
+
+// IsPrivate check whether only some users may connect to this channel.
+func (c *channel) IsPrivate() bool {
+    return c.AccessMode() != AccessPublic
+}
+
+// Summary retrieve a snapshot of this channel.
+func (c *channel) Summary() ChannelSummary {
+    c.lockUsers.Lock()
+    users := len(c.users)
+    c.lockUsers.Unlock()
+
+    return ChannelSummary {
+        ChannelInfo: c.Info(),
+        Users: users,
+        LastActivity: c.LastActivity(),
+        Private: c.IsPrivate(),
+    }
+}
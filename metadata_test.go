@@ -0,0 +1,61 @@
+package go_chat_i_guess
+
+import (
+    "testing"
+    "time"
+)
+
+// TestMetadata check whether the channel's metadata is kept and whether
+// topic changes are broadcast as typed events.
+func TestMetadata(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.Controller = JSONEncoder {}
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    before := time.Now()
+    s.CreateChannelWithOwner(cn, u1)
+    c, _ := s.GetChannel(cn)
+
+    if want, got := u1, c.Creator(); want != got {
+        t.Errorf("Invalid creator: expected '%s' but got '%s'", want, got)
+    } else if c.Created().Before(before) {
+        t.Errorf("Invalid creation time: %+v", c.Created())
+    }
+
+    c.SetDescription("A channel")
+    c.SetAttribute("lang", "en")
+    if val, ok := c.GetAttribute("lang"); !ok || val != "en" {
+        t.Errorf("Invalid attribute: '%s', %v", val, ok)
+    }
+
+    info := c.Info()
+    if want, got := "A channel", info.Description; want != got {
+        t.Errorf("Invalid description: expected '%s' but got '%s'", want, got)
+    }
+    // The snapshot must not be affected by later changes.
+    c.RemoveAttribute("lang")
+    if _, ok := c.GetAttribute("lang"); ok {
+        t.Error("Attribute wasn't removed")
+    } else if want, got := "en", info.Attributes["lang"]; want != got {
+        t.Errorf("Snapshot was modified: expected '%s' but got '%s'", want, got)
+    }
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c.ConnectUser(u1, c1)
+    recvEnvelope(t, _c1)
+
+    c.SetTopic("news", u1)
+    env := recvEnvelope(t, _c1)
+    if want, got := "topic", env.Type; want != got {
+        t.Errorf("Invalid envelope type: expected '%s' but got '%s'", want, got)
+    } else if want, got := "news", env.Metadata["topic"]; want != got {
+        t.Errorf("Invalid topic: expected '%s' but got '%s'", want, got)
+    } else if want, got := u1, env.Metadata["by"]; want != got {
+        t.Errorf("Invalid author: expected '%s' but got '%s'", want, got)
+    }
+}
@@ -0,0 +1,192 @@
+package go_chat_i_guess
+
+import (
+    "sort"
+    "sync"
+    "time"
+)
+
+// Delay between executions of the sanction cleanup routine.
+const defSanctionCleanupDelay = time.Minute
+
+// Sanction is a ban or a mute applied to a user in a channel.
+type Sanction struct {
+    // Username of the sanctioned user.
+    Username string
+
+    // Date when the sanction was applied.
+    Date time.Time
+
+    // Expires is when the sanction gets automatically lifted. If this is
+    // the zero time, the sanction never expires.
+    Expires time.Time
+}
+
+// expired check whether the sanction has expired by `now`.
+func (s *Sanction) expired(now time.Time) bool {
+    return !s.Expires.IsZero() && !now.Before(s.Expires)
+}
+
+// sanctions is a list of sanctioned users, indexed by their usernames.
+type sanctions struct {
+    // list of sanctions, indexed by the sanctioned user.
+    list map[string]Sanction
+
+    // lock synchronizes access to `list`.
+    lock sync.Mutex
+}
+
+// newSanctions create a new, empty, list of sanctions.
+func newSanctions() *sanctions {
+    return &sanctions {
+        list: make(map[string]Sanction),
+    }
+}
+
+// add sanction `username` for `duration`. If `duration` isn't positive,
+// the sanction never expires.
+//
+// Any previous sanction for the same user is replaced.
+func (s *sanctions) add(username string, duration time.Duration) {
+    now := time.Now()
+
+    val := Sanction {
+        Username: username,
+        Date: now,
+    }
+    if duration > 0 {
+        val.Expires = now.Add(duration)
+    }
+
+    s.lock.Lock()
+    s.list[username] = val
+    s.lock.Unlock()
+}
+
+// remove the sanction for `username`, returning whether they were
+// sanctioned.
+func (s *sanctions) remove(username string) bool {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    val, ok := s.list[username]
+    delete(s.list, username)
+
+    return ok && !val.expired(time.Now())
+}
+
+// has check whether `username` is currently sanctioned.
+func (s *sanctions) has(username string) bool {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    val, ok := s.list[username]
+    return ok && !val.expired(time.Now())
+}
+
+// get retrieve every active sanction, sorted by username.
+func (s *sanctions) get() []Sanction {
+    var res []Sanction
+
+    now := time.Now()
+
+    s.lock.Lock()
+    for _, val := range s.list {
+        if !val.expired(now) {
+            res = append(res, val)
+        }
+    }
+    s.lock.Unlock()
+
+    sort.Slice(res, func(i, j int) bool {
+        return res[i].Username < res[j].Username
+    })
+
+    return res
+}
+
+// expire remove every sanction that has expired by `now`.
+func (s *sanctions) expire(now time.Time) {
+    s.lock.Lock()
+    for key, val := range s.list {
+        if val.expired(now) {
+            delete(s.list, key)
+        }
+    }
+    s.lock.Unlock()
+}
+
+// Ban `username` from this channel for `duration`, removing them from the
+// channel if they are connected. If `duration` isn't positive, the ban
+// never expires.
+//
+// Banning an already banned user replaces their previous ban.
+func (c *channel) Ban(username string, duration time.Duration) error {
+    c.bans.add(username, duration)
+
+    if c.debugLog && c.logger != nil {
+        c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Banning user...\n\tchannel: \"%s\"\n\tuser: \"%s\"\n\tduration: %s",
+                c.name, username, duration)
+    }
+
+    c.lockUsers.Lock()
+    if _, ok := c.users[username]; ok {
+        c.RemoveUserUnsafe(username)
+    }
+    c.lockUsers.Unlock()
+
+    return nil
+}
+
+// Unban `username` from this channel.
+//
+// Fails with `InvalidUser` if the user isn't banned.
+func (c *channel) Unban(username string) error {
+    if !c.bans.remove(username) {
+        return InvalidUser
+    }
+
+    return nil
+}
+
+// ListBans retrieve every user currently banned from this channel.
+func (c *channel) ListBans() []Sanction {
+    return c.bans.get()
+}
+
+// Mute `username` in this channel for `duration`, so their broadcasts get
+// dropped. If `duration` isn't positive, the mute never expires.
+//
+// Muting an already muted user replaces their previous mute.
+func (c *channel) Mute(username string, duration time.Duration) error {
+    c.mutes.add(username, duration)
+
+    if c.debugLog && c.logger != nil {
+        c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Muting user...\n\tchannel: \"%s\"\n\tuser: \"%s\"\n\tduration: %s",
+                c.name, username, duration)
+    }
+
+    return nil
+}
+
+// Unmute `username` in this channel.
+//
+// Fails with `InvalidUser` if the user isn't muted.
+func (c *channel) Unmute(username string) error {
+    if !c.mutes.remove(username) {
+        return InvalidUser
+    }
+
+    return nil
+}
+
+// ListMutes retrieve every user currently muted in this channel.
+func (c *channel) ListMutes() []Sanction {
+    return c.mutes.get()
+}
+
+// expireSanctions remove every ban and mute that has expired by `now`.
+func (c *channel) expireSanctions(now time.Time) {
+    c.bans.expire(now)
+    c.mutes.expire(now)
+}
@@ -0,0 +1,131 @@
+package go_chat_i_guess
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// TestBan check whether banned users are removed and refused by the
+// channel, until their ban expires.
+func TestBan(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.SanctionCleanupDelay = time.Millisecond * 10
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    err := c.ConnectUser(u1, c1)
+    if err != nil {
+        t.Fatalf("Failed to connect %s to %s: %+v", u1, cn, err)
+    }
+
+    err = c.Ban(u1, conf.SanctionCleanupDelay)
+    if err != nil {
+        t.Fatalf("Failed to ban %s: %+v", u1, err)
+    } else if !_c1.isClosed() {
+        t.Errorf("%s wasn't disconnected after being banned", u1)
+    }
+
+    list := c.ListBans()
+    if len(list) != 1 {
+        t.Fatalf("Invalid list of bans: %+v", list)
+    } else if want, got := u1, list[0].Username; want != got {
+        t.Errorf("Invalid banned user: expected '%s' but got '%s'", want, got)
+    }
+
+    tk, _ := s.RequestToken(u1, cn)
+    err = s.Connect(tk, NewMockConn())
+    if err == nil {
+        t.Error("Banned user successfully connected")
+    } else if got, ok := err.(ChatError); !ok {
+        t.Errorf("Invalid error! Expected a 'ChatError' but got '%+v'", err)
+    } else if want := UserBanned; want != got {
+        t.Errorf("Invalid error! Expected '%+v' but got '%+v'", want, got)
+    }
+
+    // Wait for the ban to be removed by the server.
+    time.Sleep(conf.SanctionCleanupDelay * 2)
+    if list := c.ListBans(); len(list) != 0 {
+        t.Errorf("Ban didn't expire: %+v", list)
+    }
+    tk, _ = s.RequestToken(u1, cn)
+    err = s.Connect(tk, NewMockConn())
+    if err != nil {
+        t.Errorf("Failed to connect after the ban expired: %+v", err)
+    }
+
+    if want, got := InvalidUser, c.Unban(u1); want != got {
+        t.Errorf("Invalid error! Expected '%+v' but got '%+v'", want, got)
+    }
+}
+
+// TestMute check whether messages sent by muted users are dropped.
+func TestMute(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    err := c.ConnectUser(u1, c1)
+    if err != nil {
+        t.Fatalf("Failed to connect %s to %s: %+v", u1, cn, err)
+    }
+    _c1.TestRecv(time.Millisecond * 5)
+
+    c.Mute(u1, 0)
+    _c1.TestSend("hello")
+    msg, err := _c1.TestRecv(time.Millisecond * 5)
+    if err != nil {
+        t.Errorf("%s wasn't told that they are muted: %+v", u1, err)
+    } else if !strings.Contains(msg, "muted") {
+        t.Errorf("Muted message was broadcast: %s", msg)
+    }
+    if list := c.GetHistory(time.Time{}, 1); len(list) == 1 && list[0].Message == "hello" {
+        t.Errorf("Muted message was logged")
+    }
+
+    err = c.Unmute(u1)
+    if err != nil {
+        t.Errorf("Failed to unmute %s: %+v", u1, err)
+    }
+    _c1.TestSend("hello")
+    msg, err = _c1.TestRecv(time.Millisecond * 5)
+    if err != nil {
+        t.Errorf("%s failed to receive their message: %+v", u1, err)
+    } else if !strings.HasSuffix(msg, "hello") {
+        t.Errorf("Invalid message: %s", msg)
+    }
+}
+
+// TestSanctionCleanupDelay check whether a configuration without the
+// sanction cleanup delay still starts the server.
+func TestSanctionCleanupDelay(t *testing.T) {
+    s := NewServerConf(ServerConf {
+        ReadBuf: 1024,
+        WriteBuf: 1024,
+        TokenDeadline: defTokenDeadline,
+        TokenCleanupDelay: defTokenCleanupDelay,
+        ChannelIdleTimeout: defIdleTimeout,
+        ChannelCleanupDelay: defChannelCleanupDelay,
+    })
+    defer s.Close()
+
+    if want, got := defSanctionCleanupDelay, s.GetConf().SanctionCleanupDelay; want != got {
+        t.Errorf("Invalid sanction cleanup delay! Expected %s but got %s", want, got)
+    }
+}
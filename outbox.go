@@ -0,0 +1,137 @@
+package go_chat_i_guess
+
+import (
+    "sync"
+)
+
+// Default number of messages that may be queued for a user.
+const defSendQueueSize = 64
+
+// OverflowPolicy defines what happens when a message is sent to a user
+// whose send queue is already full.
+type OverflowPolicy uint
+
+const (
+    // Discard the oldest message in the queue to make room for the new
+    // message.
+    DropOldest OverflowPolicy = iota
+    // Discard the new message.
+    DropNewest
+    // Disconnect the user, since they can't keep up with the channel.
+    DisconnectSlow
+)
+
+// outbox is the queue of messages waiting to be sent to a user.
+//
+// Messages are pushed by the channel without ever blocking, and popped by
+// the user's writer goroutine, so a slow connection only ever delays its
+// own messages.
+type outbox struct {
+    // queue of messages, from the oldest to the newest.
+    queue []string
+
+    // depth is the maximum number of messages in `queue`.
+    depth int
+
+    // policy applied when a message is pushed into a full queue.
+    policy OverflowPolicy
+
+    // closed signals that no message may be pushed nor popped anymore.
+    closed bool
+
+    // draining signals that no message may be pushed anymore, but the
+    // queued messages may still be popped.
+    draining bool
+
+    // lock synchronizes access to the outbox.
+    lock sync.Mutex
+
+    // cond signals that the outbox has changed.
+    cond *sync.Cond
+}
+
+// newOutbox create a new, empty outbox.
+func newOutbox(depth int, policy OverflowPolicy) *outbox {
+    o := &outbox {
+        depth: depth,
+        policy: policy,
+    }
+    o.cond = sync.NewCond(&o.lock)
+
+    return o
+}
+
+// push queue `msg` to be sent, applying the outbox's policy if the queue
+// is full.
+//
+// If `force` is set, the queue's depth is ignored and the message is
+// always queued. This should only be used for bounded bursts, like when
+// replaying the channel's history.
+//
+// Fails with `ConnEOF` if the outbox is closed and with `SlowConsumer` if
+// the queue is full and the policy is `DisconnectSlow`.
+func (o *outbox) push(msg string, force bool) error {
+    o.lock.Lock()
+    defer o.lock.Unlock()
+
+    if o.closed || o.draining {
+        return ConnEOF
+    }
+
+    if !force && o.depth > 0 && len(o.queue) >= o.depth {
+        switch o.policy {
+        case DropOldest:
+            o.queue = o.queue[1:]
+        case DropNewest:
+            return nil
+        case DisconnectSlow:
+            return SlowConsumer
+        }
+    }
+
+    o.queue = append(o.queue, msg)
+    o.cond.Signal()
+
+    return nil
+}
+
+// pop wait until there's a message in the queue, and retrieve it. If the
+// outbox gets closed (or if it's drained), the second value is false.
+func (o *outbox) pop() (string, bool) {
+    o.lock.Lock()
+    defer o.lock.Unlock()
+
+    for len(o.queue) == 0 && !o.closed && !o.draining {
+        o.cond.Wait()
+    }
+
+    if o.closed || len(o.queue) == 0 {
+        o.closed = true
+        return "", false
+    }
+
+    msg := o.queue[0]
+    o.queue[0] = ""
+    o.queue = o.queue[1:]
+
+    return msg, true
+}
+
+// drain stop accepting new messages, letting the writer goroutine pop the
+// queued messages before the outbox gets closed.
+func (o *outbox) drain() {
+    o.lock.Lock()
+    o.draining = true
+    o.cond.Broadcast()
+    o.lock.Unlock()
+}
+
+// close the outbox, discarding every queued message and waking up the
+// writer goroutine.
+func (o *outbox) close() {
+    o.lock.Lock()
+    o.closed = true
+    o.queue = nil
+    o.cond.Broadcast()
+    o.lock.Unlock()
+}
@@ -0,0 +1,168 @@
+package go_chat_i_guess
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// stuckConn is a connection that never finishes sending a message.
+type stuckConn struct {
+    *mockConn
+}
+
+// SendStr blocks until the connection gets closed.
+func (sc stuckConn) SendStr(msg string) error {
+    <-sc.stop
+    return ConnEOF
+}
+
+// TestOutbox check whether the overflow policies are correctly applied.
+func TestOutbox(t *testing.T) {
+    o := newOutbox(2, DropOldest)
+    for _, msg := range []string { "a", "b", "c" } {
+        if err := o.push(msg, false); err != nil {
+            t.Errorf("Failed to push '%s': %+v", msg, err)
+        }
+    }
+    for _, want := range []string { "b", "c" } {
+        if got, _ := o.pop(); want != got {
+            t.Errorf("Invalid message: expected '%s' but got '%s'", want, got)
+        }
+    }
+
+    o = newOutbox(2, DropNewest)
+    for _, msg := range []string { "a", "b", "c" } {
+        if err := o.push(msg, false); err != nil {
+            t.Errorf("Failed to push '%s': %+v", msg, err)
+        }
+    }
+    for _, want := range []string { "a", "b" } {
+        if got, _ := o.pop(); want != got {
+            t.Errorf("Invalid message: expected '%s' but got '%s'", want, got)
+        }
+    }
+
+    o = newOutbox(2, DisconnectSlow)
+    o.push("a", false)
+    o.push("b", false)
+    if err := o.push("c", true); err != nil {
+        t.Errorf("Failed to force a message into a full queue: %+v", err)
+    }
+    if want, got := SlowConsumer, o.push("d", false); want != got {
+        t.Errorf("Invalid error! Expected '%+v' but got '%+v'", want, got)
+    }
+
+    o.close()
+    if _, ok := o.pop(); ok {
+        t.Errorf("Popped a message from a closed outbox")
+    } else if want, got := ConnEOF, o.push("e", false); want != got {
+        t.Errorf("Invalid error! Expected '%+v' but got '%+v'", want, got)
+    }
+}
+
+// TestSlowConsumer check whether a stuck user doesn't stall the channel,
+// and whether they get disconnected.
+func TestSlowConsumer(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.SendQueueSize = 2
+    conf.SendQueuePolicy = DisconnectSlow
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    err := c.ConnectUser(u1, c1)
+    if err != nil {
+        t.Fatalf("Failed to connect %s to %s: %+v", u1, cn, err)
+    }
+    _c1.TestRecv(time.Millisecond * 5)
+
+    c2 := stuckConn { NewMockConn().(*mockConn) }
+    err = c.ConnectUser(u2, c2)
+    if err != nil {
+        t.Fatalf("Failed to connect %s to %s: %+v", u2, cn, err)
+    }
+    _c1.TestRecv(time.Millisecond * 5)
+
+    // Every message should still be received by the other user, ignoring
+    // the message about the stuck user leaving.
+    for _, in := range []string { "one", "two", "three", "four" } {
+        _c1.TestSend(in)
+
+        msg, err := _c1.TestRecv(time.Millisecond * 5)
+        if err == nil && strings.Contains(msg, u2) {
+            msg, err = _c1.TestRecv(time.Millisecond * 5)
+        }
+        if err != nil {
+            t.Fatalf("%s failed to receive '%s': %+v", u1, in, err)
+        } else if !strings.HasSuffix(msg, in) {
+            t.Errorf("Invalid message: expected '%s' but got '%s'", in, msg)
+        }
+    }
+
+    // The stuck user should have been disconnected.
+    for _, name := range c.GetUsers(nil) {
+        if name == u2 {
+            t.Errorf("%s wasn't disconnected", u2)
+        }
+    }
+    if !c2.isClosed() {
+        t.Errorf("%s's connection wasn't closed", u2)
+    }
+}
+
+// TestSlowConsumerBusyChannel check whether a stuck user is disconnected
+// without stalling a channel whose queue is full.
+func TestSlowConsumerBusyChannel(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+    const senders = 16
+    const messages = 64
+
+    conf := GetDefaultServerConf()
+    conf.SendQueueSize = 1
+    conf.SendQueuePolicy = DisconnectSlow
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    // Keep the channel's queue full while the stuck user gets removed.
+    done := make(chan struct{})
+    for i := 0; i < senders; i++ {
+        go func() {
+            for j := 0; j < messages; j++ {
+                c.NewSystemBroadcast("spam")
+            }
+            done <- struct{}{}
+        }()
+    }
+
+    c1 := stuckConn { NewMockConn().(*mockConn) }
+    err := c.ConnectUser(u1, c1)
+    if err != nil {
+        t.Fatalf("Failed to connect %s to %s: %+v", u1, cn, err)
+    }
+
+    timeout := time.After(time.Second)
+    for i := 0; i < senders; i++ {
+        select {
+        case <-done:
+        case <-timeout:
+            t.Fatalf("The channel stalled after removing %s", u1)
+        }
+    }
+
+    if list := c.GetUsers(nil); len(list) != 0 {
+        t.Errorf("%s wasn't disconnected: %+v", u1, list)
+    }
+}
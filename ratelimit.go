@@ -0,0 +1,245 @@
+package go_chat_i_guess
+
+import (
+    "time"
+)
+
+// RateLimit limits how many messages may be sent, as a token bucket that
+// holds up to `Burst` messages and that refills at `Rate` messages per
+// second.
+type RateLimit struct {
+    // Rate at which the bucket refills, in messages per second. If this
+    // isn't positive, messages aren't limited.
+    Rate float64
+
+    // Burst is the maximum number of messages that may be sent at once.
+    // If this isn't positive, a single message may be sent at once.
+    Burst int
+}
+
+// enabled check whether the limit should be enforced.
+func (l RateLimit) enabled() bool {
+    return l.Rate > 0
+}
+
+// FloodAction is applied to users that keep exceeding their rate limit.
+type FloodAction uint
+
+const (
+    // Simply drop the messages over the limit.
+    FloodDrop FloodAction = iota
+    // Drop the message and whisper a warning to the sender.
+    FloodWarn
+    // Drop the message and mute the sender for
+    // `FloodControl.MuteDuration`.
+    FloodMute
+    // Drop the message and remove the sender from the channel.
+    FloodDisconnect
+)
+
+func (a FloodAction) String() string {
+    switch a {
+    case FloodDrop:
+        return "drop"
+    case FloodWarn:
+        return "warn"
+    case FloodMute:
+        return "mute"
+    case FloodDisconnect:
+        return "disconnect"
+    default:
+        return "unknown"
+    }
+}
+
+// FloodControl limits how fast users may send messages to a channel.
+//
+// Only messages sent by users (including commands, whispers and direct
+// messages) are limited. Messages over the limit are always dropped.
+type FloodControl struct {
+    // User limits the messages sent by each user in a channel.
+    User RateLimit
+
+    // Channel limits the messages sent by every user in a channel. Since
+    // exceeding this limit isn't a single user's fault, it only drops the
+    // message (and warns the sender, unless `Action` is `FloodDrop`).
+    Channel RateLimit
+
+    // Action applied to users that exceed their own limit `Violations`
+    // times in a row. Users are warned of every previous violation,
+    // unless this is `FloodDrop`.
+    Action FloodAction
+
+    // Violations is the number of consecutive messages over the user's
+    // limit before `Action` is applied. If this isn't positive, the
+    // action is applied on the first violation.
+    Violations int
+
+    // MuteDuration is for how long users get muted by `FloodMute`. If
+    // this isn't positive, the mute never expires.
+    MuteDuration time.Duration
+}
+
+// bucket of tokens consumed by each message.
+type bucket struct {
+    // tokens left in the bucket.
+    tokens float64
+
+    // last time the bucket was refilled.
+    last time.Time
+
+    // violations is the number of consecutive messages rejected by the
+    // bucket.
+    violations int
+}
+
+// newBucket create a full bucket for `limit`.
+func newBucket(limit RateLimit, now time.Time) *bucket {
+    return &bucket {
+        tokens: limit.burst(),
+        last: now,
+    }
+}
+
+// burst retrieve the maximum number of tokens in the bucket.
+func (l RateLimit) burst() float64 {
+    if l.Burst <= 0 {
+        return 1
+    }
+    return float64(l.Burst)
+}
+
+// refill the bucket with the tokens accumulated since it was last
+// refilled, returning whether it's full.
+func (b *bucket) refill(limit RateLimit, now time.Time) bool {
+    if now.After(b.last) {
+        b.tokens += now.Sub(b.last).Seconds() * limit.Rate
+        b.last = now
+    }
+    if max := limit.burst(); b.tokens >= max {
+        b.tokens = max
+        return true
+    }
+    return false
+}
+
+// take a token from the bucket, returning whether it had any.
+func (b *bucket) take(limit RateLimit, now time.Time) bool {
+    b.refill(limit, now)
+    if b.tokens < 1 {
+        b.violations++
+        return false
+    }
+
+    b.tokens--
+    b.violations = 0
+    return true
+}
+
+// flood tracks the rate limits of a channel. It must only be accessed
+// from the channel's goroutine.
+type flood struct {
+    // conf of the limits.
+    conf FloodControl
+
+    // channel limits every message sent to the channel.
+    channel *bucket
+
+    // users maps each user to their limit.
+    users map[string]*bucket
+}
+
+// newFlood create the rate limits of a channel.
+func newFlood(conf FloodControl) *flood {
+    return &flood {
+        conf: conf,
+        channel: newBucket(conf.Channel, time.Now()),
+        users: make(map[string]*bucket),
+    }
+}
+
+// prune forget every user whose bucket is full by `now`, as it's
+// equivalent to a new bucket.
+func (f *flood) prune(now time.Time) {
+    for username, b := range f.users {
+        if b.refill(f.conf.User, now) {
+            delete(f.users, username)
+        }
+    }
+}
+
+// limitFlood check whether the user message `msg` is within the channel's
+// rate limits, applying the configured action if it isn't.
+//
+// This must only be called from the channel's goroutine.
+func (c *channel) limitFlood(msg *Message) bool {
+    f := c.flood
+    now := msg.Date
+
+    if limit := f.conf.User; limit.enabled() {
+        b, ok := f.users[msg.From]
+        if !ok {
+            b = newBucket(limit, now)
+            f.users[msg.From] = b
+        }
+
+        if !b.take(limit, now) {
+            c.onFlood(msg.From, b)
+            return false
+        }
+    }
+
+    if limit := f.conf.Channel; limit.enabled() && !f.channel.take(limit, now) {
+        if c.logger != nil {
+            c.logger.Printf("[INFO] go_chat_i_guess/channel: Channel exceeded its rate limit.\n\tchannel: \"%s\"\n\tuser: \"%s\"",
+                    c.name, msg.From)
+        }
+
+        if f.conf.Action != FloodDrop {
+            c.whisperNow("Too many messages are being sent to " + c.name + ". Please, slow down.",
+                    msg.From)
+        }
+        return false
+    }
+
+    return true
+}
+
+// onFlood apply the configured action to `username`, whose messages are
+// over their limit.
+func (c *channel) onFlood(username string, b *bucket) {
+    conf := c.flood.conf
+
+    violations := conf.Violations
+    if violations <= 0 {
+        violations = 1
+    }
+
+    if c.logger != nil {
+        c.logger.Printf("[INFO] go_chat_i_guess/channel: User exceeded their rate limit.\n\tchannel: \"%s\"\n\tuser: \"%s\"\n\tviolations: %d",
+                c.name, username, b.violations)
+    }
+
+    if conf.Action == FloodDrop {
+        return
+    } else if conf.Action == FloodWarn || b.violations < violations {
+        c.whisperNow("You are sending messages too fast to " + c.name + ". Please, slow down.",
+                username)
+        return
+    }
+
+    b.violations = 0
+    switch conf.Action {
+    case FloodMute:
+        c.Mute(username, conf.MuteDuration)
+        c.whisperNow("You were muted in " + c.name + " for flooding it.",
+                username)
+    case FloodDisconnect:
+        if c.logger != nil {
+            c.logger.Printf("[INFO] go_chat_i_guess/channel: Removing flooding user...\n\tchannel: \"%s\"\n\tuser: \"%s\"",
+                    c.name, username)
+        }
+
+        c.kickUser(username, "You were removed from " + c.name + " for flooding it.")
+    }
+}
@@ -0,0 +1,157 @@
+package go_chat_i_guess
+
+import (
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// TestBucket check whether the token bucket refills at the requested rate.
+func TestBucket(t *testing.T) {
+    limit := RateLimit {
+        Rate: 10,
+        Burst: 2,
+    }
+    now := time.Now()
+    b := newBucket(limit, now)
+
+    for i, test := range []struct {
+        delay time.Duration
+        want bool
+    } {
+        { 0, true },
+        { 0, true },
+        { 0, false },
+        { time.Millisecond * 50, false },
+        { time.Millisecond * 50, true },
+        { time.Millisecond * 50, false },
+        { time.Second, true },
+        { 0, true },
+        { 0, false },
+    } {
+        now = now.Add(test.delay)
+        if got := b.take(limit, now); test.want != got {
+            t.Errorf("Invalid result for take %d! Expected %+v but got %+v", i, test.want, got)
+        }
+    }
+    if want, got := 1, b.violations; want != got {
+        t.Errorf("Invalid number of violations! Expected %d but got %d", want, got)
+    }
+}
+
+// TestFloodControl check whether users get warned and, later, removed
+// for flooding a channel.
+func TestFloodControl(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.FloodControl = FloodControl {
+        User: RateLimit {
+            Rate: 0.001,
+            Burst: 2,
+        },
+        Action: FloodDisconnect,
+        Violations: 2,
+    }
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c.ConnectUser(u1, c1)
+    _c1.TestRecv(time.Millisecond * 5)
+
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+    c.ConnectUser(u2, c2)
+    _c1.TestRecv(time.Millisecond * 5)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    for i, want := range []string {
+        "msg 0",
+        "msg 1",
+        "too fast",
+        "removed",
+    } {
+        c.NewBroadcast("msg " + strconv.Itoa(i), u1)
+        msg, err := _c1.TestRecv(time.Millisecond * 5)
+        if err != nil || !strings.Contains(msg, want) {
+            t.Errorf("Invalid message %d! Expected '%s' but got '%s' (%+v)", i, want, msg, err)
+        }
+    }
+
+    // Only the messages within the limit reach other users.
+    for _, want := range []string { "msg 0", "msg 1", "exited" } {
+        msg, err := _c2.TestRecv(time.Millisecond * 5)
+        if err != nil || !strings.Contains(msg, want) {
+            t.Errorf("Invalid message! Expected '%s' but got '%s' (%+v)", want, msg, err)
+        }
+    }
+
+    if list := c.GetUsers(nil); len(list) != 1 || list[0] != u2 {
+        t.Errorf("Flooding user wasn't removed: %+v", list)
+    }
+
+    // Other users aren't affected by the flooding user.
+    c.NewBroadcast("hi", u2)
+    if msg, err := _c2.TestRecv(time.Millisecond * 5); err != nil || !strings.HasSuffix(msg, "hi") {
+        t.Errorf("User was limited by another user: '%s' (%+v)", msg, err)
+    }
+}
+
+// TestFloodControlBusyChannel check whether the notice sent to a flooding
+// user is encoded by the channel's goroutine.
+func TestFloodControlBusyChannel(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+
+    gate := make(chan struct{})
+    enc := &exclusiveEncoder { next: gatedEncoder { nil, gate } }
+
+    conf := GetDefaultServerConf()
+    conf.Encoder = enc
+    conf.FloodControl = FloodControl {
+        User: RateLimit {
+            Rate: 0.001,
+            Burst: 1,
+        },
+        Action: FloodDisconnect,
+    }
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c.ConnectUser(u1, c1)
+    _c1.TestRecv(time.Millisecond * 5)
+
+    // Remove the user just before the channel starts encoding a message.
+    c.NewBroadcast("msg 0", u1)
+    c.NewBroadcast("msg 1", u1)
+    c.NewSystemBroadcast("gate")
+    time.Sleep(time.Millisecond * 5)
+    close(gate)
+
+    time.Sleep(time.Millisecond * 5)
+    if atomic.LoadInt32(&enc.concurrent) != 0 {
+        t.Error("The notice was encoded concurrently with another message")
+    }
+
+    var removed bool
+    for len(_c1.fromServer) > 0 {
+        removed = removed || strings.Contains(<-_c1.fromServer, "removed")
+    }
+    if !removed {
+        t.Errorf("Flooding user wasn't notified")
+    }
+}
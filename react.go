@@ -0,0 +1,155 @@
+package go_chat_i_guess
+
+import (
+    "sort"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// maxEmojiBytes is the maximum length of a reaction, in bytes. Emojis
+// composed of many code points (e.g., families and flags) may be quite
+// long, but anything longer than this is surely not an emoji.
+const maxEmojiBytes = 32
+
+// validEmoji check whether `emoji` may be used as a reaction. Since the
+// set of emojis keeps growing, anything short and without spaces or
+// control characters is accepted.
+func validEmoji(emoji string) bool {
+    if len(emoji) == 0 || len(emoji) > maxEmojiBytes || !utf8.ValidString(emoji) {
+        return false
+    }
+
+    return strings.IndexFunc(emoji, func(r rune) bool {
+        return unicode.IsSpace(r) || unicode.IsControl(r)
+    }) == -1
+}
+
+// AddReaction react to the message identified by `id` with `emoji`,
+// broadcasting the change as a `KindReaction` event.
+//
+// Only messages still kept in the channel's history may be reacted to,
+// and only by users allowed to use `PermSend`. Reactions are subject to
+// the same mutes and rate limits as new messages.
+//
+// Fails with `MessageNotFound` if the message isn't in the history, with
+// `InvalidReaction` if `emoji` can't be used as a reaction, and with
+// `PermissionDenied` if `username` may not react to messages.
+func (c *channel) AddReaction(id, username, emoji string) error {
+    event, err := c.reactionEvent(id, username, emoji, "add")
+    if err == nil {
+        c.queue(event)
+    }
+    return err
+}
+
+// RemoveReaction remove the reaction `emoji` of `username` from the
+// message identified by `id`, broadcasting the change as a
+// `KindReaction` event.
+//
+// Fails like `AddReaction()`. Removing a reaction that the user didn't
+// add is silently ignored.
+func (c *channel) RemoveReaction(id, username, emoji string) error {
+    event, err := c.reactionEvent(id, username, emoji, "remove")
+    if err == nil {
+        c.queue(event)
+    }
+    return err
+}
+
+// reactionEvent create the `KindReaction` event to `action` the reaction
+// `emoji` of `username` to the message identified by `id`.
+func (c *channel) reactionEvent(id, username, emoji, action string) (*Message, error) {
+    _, err := c.findMessage(id)
+    if err != nil {
+        return nil, err
+    } else if !validEmoji(emoji) {
+        return nil, InvalidReaction
+    } else if len(username) == 0 || !c.HasPermission(username, PermSend) {
+        return nil, PermissionDenied
+    }
+
+    text := username + " reacted with " + emoji
+    if action == "remove" {
+        text = username + " removed their " + emoji + " reaction"
+    }
+
+    return c.makeMessage(KindReaction, text, username, "", map[string]string {
+        "id": id,
+        "emoji": emoji,
+        "action": action,
+    }), nil
+}
+
+// GetReactions retrieve the reactions to the message identified by `id`,
+// mapping each emoji to the users that reacted with it, sorted by name.
+//
+// Fails with `MessageNotFound` if the message isn't in the history.
+func (c *channel) GetReactions(id string) (map[string][]string, error) {
+    msg, err := c.findMessage(id)
+    if err != nil {
+        return nil, err
+    }
+
+    // The lists are never modified once set, so only the map is copied.
+    reactions := make(map[string][]string, len(msg.Reactions))
+    for emoji, users := range msg.Reactions {
+        reactions[emoji] = users
+    }
+    return reactions, nil
+}
+
+// react apply the reaction event `event` to the message it targets,
+// updating the channel's history and, if it supports it, the channel's
+// store. On success, every reaction to the message is stored in
+// `event.Reactions`.
+//
+// Returns whether the reactions to the message changed.
+//
+// This must only be called from the channel's goroutine.
+func (c *channel) react(event *Message) (bool, error) {
+    msg, err := c.findMessage(event.Metadata["id"])
+    if err != nil {
+        return false, err
+    }
+
+    emoji := event.Metadata["emoji"]
+    add := event.Metadata["action"] == "add"
+
+    users := msg.Reactions[emoji]
+    idx := sort.SearchStrings(users, event.From)
+    found := idx < len(users) && users[idx] == event.From
+    if found == add {
+        return false, nil
+    }
+
+    // Copies of the message share its reactions, so they must be
+    // replaced instead of modified.
+    var list []string
+    list = append(list, users[:idx]...)
+    if add {
+        list = append(list, event.From)
+        list = append(list, users[idx:]...)
+    } else {
+        list = append(list, users[idx+1:]...)
+    }
+
+    reactions := make(map[string][]string, len(msg.Reactions) + 1)
+    for k, v := range msg.Reactions {
+        reactions[k] = v
+    }
+    if len(list) > 0 {
+        reactions[emoji] = list
+    } else {
+        delete(reactions, emoji)
+    }
+    if len(reactions) == 0 {
+        reactions = nil
+    }
+
+    msg.Reactions = reactions
+    c.rewrite(&msg, true)
+
+    event.Reactions = reactions
+    return true, nil
+}
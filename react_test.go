@@ -0,0 +1,109 @@
+package go_chat_i_guess
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// TestReactions check whether users may react to messages, and whether
+// the reactions are kept in the history and replayed.
+func TestReactions(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const u3 = "user3"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.HistoryReplay = 1
+    conf.Store = NewMemoryStore(conf.HistorySize)
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c.ConnectUser(u1, c1)
+    _c1.TestRecv(time.Millisecond * 5)
+
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+    c.ConnectUser(u2, c2)
+    _c1.TestRecv(time.Millisecond * 5)
+    // The second user also receives the first one joining, replayed.
+    _c2.TestRecv(time.Millisecond * 5)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    c.NewBroadcast("hello", u1)
+    _c1.TestRecv(time.Millisecond * 5)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    history := c.GetHistory(time.Time{}, 1)
+    if len(history) != 1 || history[0].Message != "hello" {
+        t.Fatalf("Invalid history: %+v", history)
+    }
+    id := history[0].ID
+
+    if err := c.AddReaction("missing", u2, "👍"); err != MessageNotFound {
+        t.Errorf("Invalid error for reacting to a missing message! Expected '%+v' but got '%+v'", MessageNotFound, err)
+    }
+    if err := c.AddReaction(id, u2, "not an emoji"); err != InvalidReaction {
+        t.Errorf("Invalid error for an invalid reaction! Expected '%+v' but got '%+v'", InvalidReaction, err)
+    }
+
+    for i, test := range []struct {
+        user string
+        emoji string
+        add bool
+        want string
+    } {
+        { u2, "👍", true, "reacted with 👍 [👍 1]" },
+        { u1, "👍", true, "reacted with 👍 [👍 2]" },
+        { u1, "🎉", true, "reacted with 🎉 [🎉 1, 👍 2]" },
+        { u2, "👍", false, "removed their 👍 reaction [🎉 1, 👍 1]" },
+    } {
+        var err error
+        if test.add {
+            err = c.AddReaction(id, test.user, test.emoji)
+        } else {
+            err = c.RemoveReaction(id, test.user, test.emoji)
+        }
+        if err != nil {
+            t.Fatalf("Couldn't react to the message in test %d: %+v", i, err)
+        }
+
+        for _, conn := range []*mockConn { _c1, _c2 } {
+            if msg, err := conn.TestRecv(time.Millisecond * 5); err != nil || !strings.HasSuffix(msg, test.user + " " + test.want) {
+                t.Errorf("Invalid reaction event for test %d: '%s' (%+v)", i, msg, err)
+            }
+        }
+    }
+
+    // Repeated reactions don't change anything, so they aren't broadcast.
+    c.AddReaction(id, u1, "👍")
+    if msg, err := _c2.TestRecv(time.Millisecond * 5); err == nil {
+        t.Errorf("Repeated reaction was broadcast: '%s'", msg)
+    }
+
+    reactions, err := c.GetReactions(id)
+    if err != nil {
+        t.Fatalf("Couldn't retrieve the reactions: %+v", err)
+    } else if len(reactions) != 2 || len(reactions["👍"]) != 1 || reactions["👍"][0] != u1 {
+        t.Errorf("Invalid reactions: %+v", reactions)
+    }
+
+    stored, _ := s.GetConf().Store.Range(cn, StoreQuery { Limit: 1 })
+    if len(stored) != 1 || stored[0].ID != id || len(stored[0].Reactions) != 2 {
+        t.Errorf("Reactions weren't stored: %+v", stored)
+    }
+
+    // Users that join later receive the message with its reactions.
+    c3 := NewMockConn()
+    _c3 := c3.(*mockConn)
+    c.ConnectUser(u3, c3)
+    if msg, err := _c3.TestRecv(time.Millisecond * 5); err != nil || !strings.HasSuffix(msg, u1 + ": hello [🎉 1, 👍 1]") {
+        t.Errorf("Invalid replayed message: '%s' (%+v)", msg, err)
+    }
+}
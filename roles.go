@@ -0,0 +1,182 @@
+package go_chat_i_guess
+
+import (
+    "sync"
+)
+
+// Role of a user within a channel. Roles are ordered, so every role has
+// at least the same permissions as the roles before it.
+type Role uint
+
+const (
+    // A user that may only read the channel.
+    RoleGuest Role = iota
+    // A regular user. This is the role of every user without an
+    // explicitly assigned role.
+    RoleMember
+    // A user that may moderate the channel.
+    RoleOperator
+    // A user that owns the channel.
+    RoleOwner
+)
+
+func (r Role) String() string {
+    switch r {
+    case RoleGuest:
+        return "guest"
+    case RoleMember:
+        return "member"
+    case RoleOperator:
+        return "operator"
+    case RoleOwner:
+        return "owner"
+    default:
+        return "unknown"
+    }
+}
+
+// Permission to execute some operation within a channel.
+type Permission uint
+
+const (
+    // Broadcast messages to the channel.
+    PermSend Permission = iota
+    // Whisper to other users in the channel.
+    PermWhisper
+    // Remove other users from the channel.
+    PermKick
+    // Ban and mute other users.
+    PermBan
+    // Change the channel's topic.
+    PermSetTopic
+    // Close the channel.
+    PermClose
+    // Invite users and manage who may connect to the channel.
+    PermAccess
+    // Delete messages sent by other users.
+    PermDelete
+)
+
+func (p Permission) String() string {
+    switch p {
+    case PermSend:
+        return "send"
+    case PermWhisper:
+        return "whisper"
+    case PermKick:
+        return "kick"
+    case PermBan:
+        return "ban"
+    case PermSetTopic:
+        return "set topic"
+    case PermClose:
+        return "close channel"
+    case PermAccess:
+        return "manage access"
+    case PermDelete:
+        return "delete messages"
+    default:
+        return "unknown"
+    }
+}
+
+// Permissions maps each permission to the lowest role allowed to use it.
+type Permissions map[Permission]Role
+
+// DefaultPermissions retrieve the permissions used by channels, unless
+// overridden by `ServerConf.Permissions`:
+//
+//  - Members may send messages and whisper
+//  - Operators may also kick, ban, change the topic and delete messages
+//    sent by other users
+//  - Owners may also close the channel and manage who may connect to it
+func DefaultPermissions() Permissions {
+    return Permissions {
+        PermSend: RoleMember,
+        PermWhisper: RoleMember,
+        PermKick: RoleOperator,
+        PermBan: RoleOperator,
+        PermSetTopic: RoleOperator,
+        PermClose: RoleOwner,
+        PermAccess: RoleOwner,
+        PermDelete: RoleOperator,
+    }
+}
+
+// roles tracks the role of every user in a channel, and the role required
+// by each permission.
+type roles struct {
+    // users maps usernames to their explicitly assigned role.
+    users map[string]Role
+
+    // perms maps each permission to the lowest role allowed to use it.
+    perms Permissions
+
+    // lock synchronizes access to the roles.
+    lock sync.Mutex
+}
+
+// newRoles create a new set of roles with the default permissions,
+// overridden by `perms`, and with `owner` as the channel's owner. If
+// `owner` is empty, the channel doesn't have an owner.
+func newRoles(perms Permissions, owner string) *roles {
+    r := &roles {
+        users: make(map[string]Role),
+        perms: DefaultPermissions(),
+    }
+
+    for perm, role := range perms {
+        r.perms[perm] = role
+    }
+    if len(owner) > 0 {
+        r.users[owner] = RoleOwner
+    }
+
+    return r
+}
+
+// GetRole retrieve the role of `username` in this channel.
+func (c *channel) GetRole(username string) Role {
+    c.roles.lock.Lock()
+    defer c.roles.lock.Unlock()
+
+    if role, ok := c.roles.users[username]; ok {
+        return role
+    }
+    return RoleMember
+}
+
+// SetRole set the role of `username` in this channel.
+func (c *channel) SetRole(username string, role Role) {
+    c.roles.lock.Lock()
+    c.roles.users[username] = role
+    c.roles.lock.Unlock()
+
+    if c.debugLog && c.logger != nil {
+        c.logger.Printf("[DEBUG] go_chat_i_guess/channel: Setting user's role...\n\tchannel: \"%s\"\n\tuser: \"%s\"\n\trole: \"%s\"",
+                c.name, username, role)
+    }
+}
+
+// SetPermission set the lowest role allowed to use `perm` in this channel.
+func (c *channel) SetPermission(perm Permission, role Role) {
+    c.roles.lock.Lock()
+    c.roles.perms[perm] = role
+    c.roles.lock.Unlock()
+}
+
+// HasPermission check whether `username` is allowed to use `perm` in this
+// channel.
+func (c *channel) HasPermission(username string, perm Permission) bool {
+    role := c.GetRole(username)
+
+    c.roles.lock.Lock()
+    required, ok := c.roles.perms[perm]
+    c.roles.lock.Unlock()
+
+    // Unknown permissions are restricted to the channel's owners.
+    if !ok {
+        required = RoleOwner
+    }
+    return role >= required
+}
@@ -0,0 +1,75 @@
+package go_chat_i_guess
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// TestRoles check whether roles and permissions are correctly tracked.
+func TestRoles(t *testing.T) {
+    const owner = "owner"
+    const u1 = "user1"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.Permissions = Permissions { PermSetTopic: RoleMember }
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    err := s.CreateChannelWithOwner(cn, owner)
+    if err != nil {
+        t.Fatalf("Failed to create a channel: %+v", err)
+    }
+    c, _ := s.GetChannel(cn)
+
+    if want, got := RoleOwner, c.GetRole(owner); want != got {
+        t.Errorf("Invalid role for %s: expected '%s' but got '%s'", owner, want, got)
+    } else if want, got := RoleMember, c.GetRole(u1); want != got {
+        t.Errorf("Invalid role for %s: expected '%s' but got '%s'", u1, want, got)
+    }
+
+    tests := []struct {
+        user string
+        perm Permission
+        want bool
+    } {
+        { owner, PermClose, true },
+        { u1, PermClose, false },
+        { u1, PermBan, false },
+        { u1, PermSend, true },
+        { u1, PermSetTopic, true },
+    }
+    for _, test := range tests {
+        if got := c.HasPermission(test.user, test.perm); test.want != got {
+            t.Errorf("Invalid permission '%s' for %s: expected '%v' but got '%v'", test.perm, test.user, test.want, got)
+        }
+    }
+
+    c.SetRole(u1, RoleOperator)
+    if !c.HasPermission(u1, PermBan) {
+        t.Errorf("Operator %s isn't allowed to ban", u1)
+    }
+    c.SetPermission(PermBan, RoleOwner)
+    if c.HasPermission(u1, PermBan) {
+        t.Errorf("Operator %s is still allowed to ban", u1)
+    }
+
+    // Guests may not send messages.
+    c.SetRole(u1, RoleGuest)
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    err = c.ConnectUser(u1, c1)
+    if err != nil {
+        t.Fatalf("Failed to connect %s to %s: %+v", u1, cn, err)
+    }
+    _c1.TestRecv(time.Millisecond * 5)
+
+    _c1.TestSend("hello")
+    msg, err := _c1.TestRecv(time.Millisecond * 5)
+    if err != nil {
+        t.Errorf("%s wasn't told that they can't send messages: %+v", u1, err)
+    } else if !strings.Contains(msg, "allowed") {
+        t.Errorf("Guest's message was broadcast: %s", msg)
+    }
+}
@@ -1,10 +1,10 @@
 package go_chat_i_guess
 
 import (
-    crand "crypto/rand"
-    "encoding/hex"
     "io"
     "log"
+    "sort"
+    "strings"
     "time"
     "sync"
 )
@@ -18,18 +18,6 @@ const defTokenCleanupDelay = time.Minute * 5
 // Delay between executions of the channel cleanup routine.
 const defChannelCleanupDelay = time.Minute * 30
 
-// Ephemeral access token received from an authenticated.
-type accessToken struct {
-    // The username for whom the token was generated.
-    username string
-
-    // The channel that this token gives access to.
-    channel string
-
-    // Expiration time for this token.
-    deadline time.Time
-}
-
 // ServerConf define various parameters that may be used to configure
 // the server.
 type ServerConf struct {
@@ -45,6 +33,20 @@ type ServerConf struct {
     // Delay between executions of the token cleanup routine.
     TokenCleanupDelay time.Duration
 
+    // Tokens issues and verifies the tokens used to connect to this
+    // server's channels. If this is nil, a provider created by
+    // `NewRandomTokenProvider(TokenStore)` is used.
+    Tokens TokenProvider
+
+    // Authorizer optionally checks every user connecting to a channel,
+    // after their token is consumed, and may veto the connection.
+    Authorizer Authorizer
+
+    // TokenStore keeps the tokens issued by the default `TokenProvider`.
+    // This is ignored if `Tokens` is set. If this is nil, a store created
+    // by `NewMemoryTokenStore()` is used.
+    TokenStore TokenStore
+
     // For how long a given channel may stay idle (without receiving any
     // connection). After this timeout expires, the channel sends a message
     // to every user, to check whether they are still connected.
@@ -56,12 +58,69 @@ type ServerConf struct {
     // Delay between executions of the channel cleanup routine.
     ChannelCleanupDelay time.Duration
 
+    // Delay between executions of the routine that removes expired bans
+    // and mutes from every channel. If this isn't positive, the routine
+    // runs every minute.
+    SanctionCleanupDelay time.Duration
+
+    // Maximum number of messages kept in each channel's history. Once
+    // this limit is reached, the oldest message gets discarded. If this
+    // is zero, no history is kept.
+    HistorySize int
+
+    // Number of messages from the channel's history replayed to a user
+    // as soon as they join a channel, before the join broadcast. If this
+    // is zero, no message is replayed.
+    HistoryReplay int
+
+    // Maximum number of messages queued to be sent to each user. Messages
+    // are sent to each user by a dedicated goroutine, so a slow user
+    // doesn't stall the channel. If this is zero, the queue is unbounded.
+    SendQueueSize int
+
+    // SendQueuePolicy defines what happens when a message is sent to a
+    // user whose queue is already full.
+    SendQueuePolicy OverflowPolicy
+
+    // SessionPolicy defines what happens when a user connects to a
+    // channel where they are already connected: the new connection may
+    // be rejected (the default), added alongside the previous ones or
+    // replace them.
+    SessionPolicy SessionPolicy
+
+    // FloodControl limits how fast users may send messages to each
+    // channel. By default, messages aren't limited.
+    FloodControl FloodControl
+
+    // Validators check, and optionally modify, every message sent by
+    // users, before it gets encoded. They are applied in order, so, for
+    // example, invalid UTF-8 should be fixed before limiting the number
+    // of characters in the message.
+    Validators []Validator
+
+    // Store optionally persists the messages broadcast by this server's
+    // channels, so a channel may be reopened with its history. If this is
+    // nil, messages are only kept in each channel's history, and are
+    // lost once the channel gets closed.
+    Store MessageStore
+
+    // Permissions optionally overrides the lowest role allowed to use
+    // each permission in this server's channels. Permissions not in this
+    // map use the value from `DefaultPermissions()`.
+    Permissions Permissions
+
     // Controller optionally processes and encodes messages received by
     // this server's channels.
     //
     // This may optionally implement `ChannelController` as well!
     Controller MessageEncoder
 
+    // Encoder optionally processes and encodes messages received by this
+    // server's channels. If set, this is used instead of `Controller`.
+    //
+    // This may optionally implement `ChannelEvents` as well!
+    Encoder MessageEncoderV2
+
     // Logger used by the chat server to report events. If this is nil, no
     // message shall be logged!
     Logger *log.Logger
@@ -80,6 +139,10 @@ func GetDefaultServerConf() ServerConf {
         TokenCleanupDelay: defTokenCleanupDelay,
         ChannelIdleTimeout: defIdleTimeout,
         ChannelCleanupDelay: defChannelCleanupDelay,
+        SanctionCleanupDelay: defSanctionCleanupDelay,
+        HistorySize: defHistorySize,
+        SendQueueSize: defSendQueueSize,
+        SendQueuePolicy: DropOldest,
     }
 }
 
@@ -94,12 +157,6 @@ type server struct {
     // Synchronizes access to `channels`.
     chanMutex sync.Mutex
 
-    // Every currently active token. The token itself is used as the map's key.
-    tokens map[string]*accessToken
-
-    // Synchronizes access to tokens.
-    tokenMutex sync.Mutex
-
     // Whether the chat server is currently running.
     running bool
 
@@ -107,6 +164,34 @@ type server struct {
     stop chan struct{}
 }
 
+// ChannelFilter selects the channels listed by `ChatServer.ListChannels`.
+type ChannelFilter struct {
+    // Prefix that must start the name of every listed channel.
+    Prefix string
+
+    // Contains is a substring that must be in the name of every listed
+    // channel.
+    Contains string
+
+    // SkipPrivate omits private channels (for example, direct channels)
+    // from the list.
+    SkipPrivate bool
+
+    // Offset is the number of matching channels skipped before the first
+    // listed channel.
+    Offset int
+
+    // Limit is the maximum number of listed channels. If this isn't
+    // positive, every matching channel is listed.
+    Limit int
+}
+
+// match check whether the channel named `name` matches the filter.
+func (f *ChannelFilter) match(name string) bool {
+    return strings.HasPrefix(name, f.Prefix) &&
+            strings.Contains(name, f.Contains)
+}
+
 // The public interfacer of the chat server.
 type ChatServer interface {
     io.Closer
@@ -126,19 +211,92 @@ type ChatServer interface {
     // RequestToken should only fail if it somehow fails to generate a token.
     RequestToken(username, channel string) (string, error)
 
+    // RequestResumeToken generate a token, just like `RequestToken`, for a
+    // user that is resuming a previous connection to `channel`.
+    //
+    // `after` is the sequence number (`Message.Seq`) of the last message
+    // received by the user. Upon connecting, every message after `after`
+    // still kept in the channel's history is replayed to the user, before
+    // any new message.
+    RequestResumeToken(username, channel string, after uint64) (string, error)
+
+    // RequestTokenWithOptions generate a token, just like `RequestToken`,
+    // customized by `opts`. The token may have its own TTL, may be used
+    // more than once and may carry claims, handed to the channel when the
+    // user connects.
+    //
+    // If the channel is in `AccessPassphrase` mode, `opts.Passphrase` must
+    // match the channel's passphrase, otherwise this fails with
+    // `InvalidPassphrase` (unless the user may connect to the channel
+    // regardless of its passphrase). Tokens requested without a
+    // passphrase, or before the channel was created, are rejected by
+    // `Connect`.
+    RequestTokenWithOptions(username, channel string,
+            opts TokenOptions) (string, error)
+
+    // RevokeToken revoke `token`, so it may not be used anymore. Users
+    // already connected with the token aren't affected.
+    RevokeToken(token string) error
+
+    // RevokeTokensForUser revoke every token issued to `username` (for
+    // example, because their account was disabled). Users already
+    // connected aren't affected.
+    RevokeTokensForUser(username string) error
+
     // CreateChannel create and start the channel with the given `name`.
     //
     // Channels are uniquely identified by their names. Also, the chat
     // server automatically removes a closed channel, regardless whether
     // it was manually closed or whether it timed out.
+    //
+    // A closed channel may be created once again, in which case it gets
+    // reopened with the messages kept in the server's `MessageStore`, if
+    // it has any.
     CreateChannel(name string) error
 
+    // CreateChannelWithOwner create and start the channel with the given
+    // `name`, just like `CreateChannel`, giving `owner` the `RoleOwner` in
+    // the channel.
+    CreateChannelWithOwner(name, owner string) error
+
     // GetChannel retrieve the channel named `name`.
     GetChannel(name string) (ChatChannel, error)
 
+    // DeleteChannel close the channel named `name`, if it's running, and
+    // remove every message stored for it, so it can't be reopened with
+    // its previous history.
+    DeleteChannel(name string) error
+
+    // ListChannels retrieve a snapshot of every running channel matched by
+    // `filter`, sorted by their names.
+    //
+    // Channels may be created, closed or removed at any time, so a
+    // channel may already be gone by the time it's returned.
+    ListChannels(filter ChannelFilter) []ChannelSummary
+
+    // SendDirect send a private message from `from` to `to`, delivering
+    // it to every channel where `to` is currently connected. The sender
+    // doesn't need to be connected to any channel.
+    //
+    // Fails with `InvalidUser` if `to` isn't connected to any channel.
+    SendDirect(from, to, msg string) error
+
+    // OpenDirectChannel create the private channel shared by `user1` and
+    // `user2`, if it isn't already running, and retrieve its name (as
+    // generated by `DirectChannelName`).
+    //
+    // Only those two users may connect to the channel, which is done
+    // just like any other channel, through `RequestToken` and `Connect`.
+    OpenDirectChannel(user1, user2 string) (string, error)
+
     // Connect a user to a channel, previously associated to `token`, using
     // `conn` to communicate with this user.
     //
+    // If the server has an `Authorizer`, it's consulted after the token
+    // gets consumed, and may veto the connection. Afterwards, the user
+    // must be allowed by the channel's `AccessMode`, otherwise this fails
+    // with `UserNotAllowed`.
+    //
     // On error, the token must be re-generated.
     //
     // If `conn` is nil, then this function will panic!
@@ -179,12 +337,103 @@ func (s *server) GetConf() ServerConf {
 //
 // See `ChatServer.RequestToken` for a more complete description.
 //
-// The generated token is generated from a cryptographically secure source and
-// encoded as a hexadecimal string.
+// The token is issued by the server's `TokenProvider`. By default, it's
+// generated from a cryptographically secure source and encoded as a
+// hexadecimal string.
 func (s *server) RequestToken(username, channel string) (string, error) {
-    var randToken [32]byte
+    return s.newToken(username, channel, TokenOptions {}, ConnectOptions {})
+}
+
+// RequestResumeToken generate a token temporarily associating the user
+// identified by `username` may connect to a `channel`, resuming a
+// previous connection whose last received message was `after`.
+//
+// See `ChatServer.RequestResumeToken` for a more complete description.
+func (s *server) RequestResumeToken(username, channel string,
+        after uint64) (string, error) {
 
-    _, err := crand.Read(randToken[:])
+    connect := ConnectOptions {
+        Resume: true,
+        ResumeAfter: after,
+    }
+    return s.newToken(username, channel, TokenOptions {}, connect)
+}
+
+// RequestTokenWithOptions generate a token temporarily associating the
+// user identified by `username` may connect to a `channel`, as customized
+// by `opts`.
+//
+// See `ChatServer.RequestTokenWithOptions` for a more complete
+// description.
+func (s *server) RequestTokenWithOptions(username, channel string,
+        opts TokenOptions) (string, error) {
+    return s.newToken(username, channel, opts, ConnectOptions {})
+}
+
+// RevokeToken revoke `token`, so it may not be used anymore.
+func (s *server) RevokeToken(token string) error {
+    err := s.conf.Tokens.Revoke(token)
+    if err != nil && s.conf.Logger != nil {
+        s.conf.Logger.Printf("[ERROR] go_chat_i_guess/server: Couldn't revoke the token.\n\ttoken: \"%s\"\n\terror: %+v",
+                token, err)
+    }
+
+    return err
+}
+
+// RevokeTokensForUser revoke every token issued to `username`.
+func (s *server) RevokeTokensForUser(username string) error {
+    err := s.conf.Tokens.RevokeUser(username)
+    if err != nil && s.conf.Logger != nil {
+        s.conf.Logger.Printf("[ERROR] go_chat_i_guess/server: Couldn't revoke the user's tokens.\n\tusername: \"%s\"\n\terror: %+v",
+                username, err)
+    } else if s.conf.DebugLog && s.conf.Logger != nil {
+        s.conf.Logger.Printf("[DEBUG] go_chat_i_guess/server: Revoked the user's tokens.\n\tusername: \"%s\"",
+                username)
+    }
+
+    return err
+}
+
+// newToken generate a token associating `username` to `channel`, as
+// customized by `opts`. The user joins the channel as described by
+// `connect`, with the claims in `opts`.
+func (s *server) newToken(username, channel string, opts TokenOptions,
+        connect ConnectOptions) (string, error) {
+
+    connect.Claims = opts.Claims
+
+    ttl := opts.TTL
+    if ttl <= 0 {
+        ttl = s.conf.TokenDeadline
+    }
+
+    now := time.Now()
+    claims := TokenClaims {
+        Username: username,
+        Channel: channel,
+        Issued: now,
+        Expires: now.Add(ttl),
+        Uses: opts.Uses,
+        Options: connect,
+    }
+
+    // Passphrases may only be checked against channels that already
+    // exist. Otherwise, the token gets rejected on `Connect` if the
+    // channel turns out to require a passphrase.
+    if c, ok := s.lookupChannel(channel); ok {
+        grant, err := c.grantAccess(username, opts.Passphrase)
+        if err != nil {
+            if s.conf.Logger != nil {
+                s.conf.Logger.Printf("[ERROR] go_chat_i_guess/server: Token requested with an invalid passphrase.\n\tchannel: \"%s\"\n\tusername: \"%s\"",
+                        channel, username)
+            }
+            return "", err
+        }
+        claims.Grant = grant
+    }
+
+    token, err := s.conf.Tokens.Issue(claims)
     if err != nil {
         if s.conf.Logger != nil {
             s.conf.Logger.Printf("[ERROR] go_chat_i_guess/server: Failed to generate a connection token.\n\tchannel: \"%s\"\n\tusername: \"%s\"\n\terror: %+v",
@@ -193,17 +442,6 @@ func (s *server) RequestToken(username, channel string) (string, error) {
         return "", err
     }
 
-    token := hex.EncodeToString(randToken[:])
-    value := &accessToken {
-        username: username,
-        channel: channel,
-        deadline: time.Now().Add(s.conf.TokenDeadline),
-    }
-
-    s.tokenMutex.Lock()
-    s.tokens[token] = value
-    s.tokenMutex.Unlock()
-
     if s.conf.DebugLog && s.conf.Logger != nil {
         s.conf.Logger.Printf("[DEBUG] go_chat_i_guess/server: Connection token generated successfully.\n\tchannel: \"%s\"\n\tusername: \"%s\"\n\ttoken: \"%s\"",
                 channel, username, token)
@@ -215,14 +453,24 @@ func (s *server) RequestToken(username, channel string) (string, error) {
 // CreateChannel create and start the channel with the given `name`.
 //
 // This shouldn't ever fail, unless there's already a channel with the
-// requested name.
+// requested name. If the server has any message stored for this channel,
+// the channel gets reopened with its previous history.
 //
 // See `ChatServer.CreateChannel` for a more complete description.
 func (s *server) CreateChannel(name string) error {
+    return s.CreateChannelWithOwner(name, "")
+}
+
+// CreateChannelWithOwner create and start the channel with the given
+// `name`, owned by `owner`.
+//
+// See `ChatServer.CreateChannelWithOwner` for a more complete description.
+func (s *server) CreateChannelWithOwner(name, owner string) error {
     s.chanMutex.Lock()
     defer s.chanMutex.Unlock()
 
-    if _, ok := s.channels[name]; ok {
+    // A closed channel may be reopened even before it gets cleaned up.
+    if c, ok := s.channels[name]; ok && !c.IsClosed() {
         if s.conf.Logger != nil {
             s.conf.Logger.Printf("[ERROR] go_chat_i_guess/server: Tried to create a channel with a duplicated name.\n\tchannel: \"%s\"",
                     name)
@@ -230,7 +478,7 @@ func (s *server) CreateChannel(name string) error {
         return DuplicatedChannel
     }
 
-    s.channels[name] = newChannel(name, s.conf)
+    s.channels[name] = newChannel(name, owner, s.conf)
     return nil
 }
 
@@ -250,29 +498,147 @@ func (s *server) GetChannel(name string) (ChatChannel, error) {
     }
 }
 
+// DeleteChannel close the channel named `name`, if it's running, and
+// remove every message stored for it.
+func (s *server) DeleteChannel(name string) error {
+    s.chanMutex.Lock()
+    c, ok := s.channels[name]
+    delete(s.channels, name)
+    s.chanMutex.Unlock()
+
+    if ok {
+        c.Close()
+    }
+
+    if s.conf.Store == nil {
+        return nil
+    }
+
+    err := s.conf.Store.DeleteChannel(name)
+    if err != nil && s.conf.Logger != nil {
+        s.conf.Logger.Printf("[ERROR] go_chat_i_guess/server: Couldn't delete the channel's stored messages.\n\tchannel: \"%s\"\n\terror: %+v",
+                name, err)
+    }
+
+    return err
+}
+
+// ListChannels retrieve a snapshot of every running channel matched by
+// `filter`.
+//
+// See `ChatServer.ListChannels` for a more complete description.
+func (s *server) ListChannels(filter ChannelFilter) []ChannelSummary {
+    var names []string
+    channels := make(map[string]ChatChannel)
+
+    // Only the channels are retrieved while the server is locked, so
+    // summarizing them doesn't block creating new channels.
+    s.chanMutex.Lock()
+    for key, val := range s.channels {
+        if !filter.match(key) || val.IsClosed() {
+            continue
+        } else if filter.SkipPrivate && val.IsPrivate() {
+            continue
+        }
+        names = append(names, key)
+        channels[key] = val
+    }
+    s.chanMutex.Unlock()
+
+    sort.Strings(names)
+
+    if filter.Offset >= len(names) {
+        return nil
+    } else if filter.Offset > 0 {
+        names = names[filter.Offset:]
+    }
+    if filter.Limit > 0 && filter.Limit < len(names) {
+        names = names[:filter.Limit]
+    }
+
+    summaries := make([]ChannelSummary, 0, len(names))
+    for _, name := range names {
+        summaries = append(summaries, channels[name].Summary())
+    }
+
+    return summaries
+}
+
 // getToken consume the given `token`, removing it from the server, and return
 // the associated `username` and `channel`.
 func (s *server) getToken(token string) (string, string, error) {
-    s.tokenMutex.Lock()
-    val, ok := s.tokens[token]
-    if ok {
-        delete(s.tokens, token)
+    val, err := s.consumeToken(token)
+    if err != nil {
+        return "", "", err
     }
-    s.tokenMutex.Unlock()
 
-    if ok {
+    return val.Username, val.Channel, nil
+}
+
+// consumeToken consume the given `token`, so it may not be used again, and
+// return its associated claims.
+func (s *server) consumeToken(token string) (TokenClaims, error) {
+    val, err := s.conf.Tokens.Consume(token)
+    if err == nil {
         if s.conf.DebugLog && s.conf.Logger != nil {
             s.conf.Logger.Printf("[DEBUG] go_chat_i_guess/server: Token consumed successfully.\n\tchannel: \"%s\"\n\tusername: \"%s\"\n\ttoken: \"%s\"",
-                    val.channel, val.username, token)
+                    val.Channel, val.Username, token)
         }
-        return val.username, val.channel, nil
+        return val, nil
     } else {
         if s.conf.Logger != nil {
-            s.conf.Logger.Printf("[ERROR] go_chat_i_guess/server: Token not found.\n\ttoken: \"%s\"",
-                    token)
+            s.conf.Logger.Printf("[ERROR] go_chat_i_guess/server: Token not found.\n\ttoken: \"%s\"\n\terror: %+v",
+                    token, err)
+        }
+        return val, err
+    }
+}
+
+// lookupChannel retrieve the running channel named `name`, if any.
+func (s *server) lookupChannel(name string) (*channel, bool) {
+    s.chanMutex.Lock()
+    val, ok := s.channels[name]
+    s.chanMutex.Unlock()
+
+    if !ok || val.IsClosed() {
+        return nil, false
+    }
+    c, ok := val.(*channel)
+    return c, ok
+}
+
+// checkAccess check whether the user may connect to the channel `c` with
+// the token granting `claims`.
+//
+// Invite-only channels are checked once again when the user actually
+// connects, so only passphrase-protected channels must be checked here.
+func (s *server) checkAccess(c ChatChannel, claims TokenClaims) error {
+    if _c, ok := c.(*channel); ok && !_c.checkGrant(claims.Username, claims.Grant) {
+        if s.conf.Logger != nil {
+            s.conf.Logger.Printf("[ERROR] go_chat_i_guess/server: Token without the channel's passphrase.\n\tchannel: \"%s\"\n\tusername: \"%s\"",
+                    claims.Channel, claims.Username)
         }
-        return "", "", InvalidToken
+        return UserNotAllowed
+    }
+
+    return nil
+}
+
+// authorize check, through the server's `Authorizer`, whether the user
+// may connect to a channel with the token granting `claims`.
+func (s *server) authorize(claims TokenClaims, conn Conn) error {
+    if s.conf.Authorizer == nil {
+        return nil
     }
+
+    err := s.conf.Authorizer.Authorize(claims.Username, claims.Channel,
+            claims, newConnDescriptor(conn))
+    if err != nil && s.conf.Logger != nil {
+        s.conf.Logger.Printf("[ERROR] go_chat_i_guess/server: Connection vetoed by the authorizer.\n\tchannel: \"%s\"\n\tusername: \"%s\"\n\terror: %+v",
+                claims.Channel, claims.Username, err)
+    }
+
+    return err
 }
 
 // Connect a user to a channel, previously associated to `token`, using
@@ -291,17 +657,27 @@ func (s *server) Connect(token string, conn Conn) error {
                 token)
     }
 
-    username, channelName, err := s.getToken(token)
+    val, err := s.consumeToken(token)
+    if err != nil {
+        return err
+    }
+
+    err = s.authorize(val, conn)
     if err != nil {
         return err
     }
 
-    c, err := s.GetChannel(channelName)
+    c, err := s.GetChannel(val.Channel)
     if err != nil {
         return err
     }
 
-    return c.ConnectUser(username, conn)
+    err = s.checkAccess(c, val)
+    if err != nil {
+        return err
+    }
+
+    return c.ConnectUserWithOptions(val.Username, conn, val.Options)
 }
 
 // ConnectAndWait connect a user to a channel, previously associated to
@@ -323,40 +699,60 @@ func (s *server) ConnectAndWait(token string, conn Conn) error {
         s.conf.Logger.Printf("[DEBUG] go_chat_i_guess/server: Trying to connect with token and blocking...\n\ttoken: \"%s\"",
                 token)
     }
-    username, channelName, err := s.getToken(token)
+    val, err := s.consumeToken(token)
+    if err != nil {
+        return err
+    }
+
+    err = s.authorize(val, conn)
+    if err != nil {
+        return err
+    }
+
+    c, err := s.GetChannel(val.Channel)
     if err != nil {
         return err
     }
 
-    c, err := s.GetChannel(channelName)
+    err = s.checkAccess(c, val)
     if err != nil {
         return err
     }
 
-    return c.ConnectUserAndWait(username, conn)
+    return c.ConnectUserWithOptionsAndWait(val.Username, conn, val.Options)
+}
+
+// expireSanctions remove every ban and mute that has expired by `now` from
+// every channel.
+func (s *server) expireSanctions(now time.Time) {
+    s.chanMutex.Lock()
+    for _, val := range s.channels {
+        if c, ok := val.(*channel); ok {
+            c.expireSanctions(now)
+        }
+    }
+    s.chanMutex.Unlock()
 }
 
 // cleanup verify, periodically, whether any object should be removed.
 func (s *server) cleanup() {
     token := time.NewTicker(s.conf.TokenCleanupDelay)
     channel := time.NewTicker(s.conf.ChannelCleanupDelay)
+    sanction := time.NewTicker(s.conf.SanctionCleanupDelay)
 
     for s.running {
         select {
-        case <-token.C:
+        case now := <-token.C:
             // Clean up connection tokens
             if s.conf.DebugLog && s.conf.Logger != nil {
                 s.conf.Logger.Printf("[DEBUG] go_chat_i_guess/server: Removing expired tokens...")
             }
 
-            s.tokenMutex.Lock()
-            now := time.Now()
-            for key, val := range s.tokens {
-                if now.After(val.deadline) {
-                    delete(s.tokens, key)
-                }
+            err := s.conf.Tokens.Sweep(now)
+            if err != nil && s.conf.Logger != nil {
+                s.conf.Logger.Printf("[ERROR] go_chat_i_guess/server: Couldn't remove the expired tokens.\n\terror: %+v",
+                        err)
             }
-            s.tokenMutex.Unlock()
         case <-channel.C:
             // Clean up channels
             if s.conf.DebugLog && s.conf.Logger != nil {
@@ -370,6 +766,13 @@ func (s *server) cleanup() {
                 }
             }
             s.chanMutex.Unlock()
+        case now := <-sanction.C:
+            // Clean up expired bans and mutes
+            if s.conf.DebugLog && s.conf.Logger != nil {
+                s.conf.Logger.Printf("[DEBUG] go_chat_i_guess/server: Removing expired sanctions...")
+            }
+
+            s.expireSanctions(now)
         case <-s.stop:
             // Do nothing and let cleanup exit
             if s.conf.DebugLog && s.conf.Logger != nil {
@@ -380,6 +783,7 @@ func (s *server) cleanup() {
 
     token.Stop()
     channel.Stop()
+    sanction.Stop()
 }
 
 // NewServerConf create a new chat server, as specified by `conf`.
@@ -388,10 +792,19 @@ func (s *server) cleanup() {
 // and release expired resources periodically. This goroutine is stopped,
 // and every resource is released, when the ChatServer gets `Close()`d.
 func NewServerConf(conf ServerConf) ChatServer {
+    if conf.Tokens == nil {
+        if conf.TokenStore == nil {
+            conf.TokenStore = NewMemoryTokenStore()
+        }
+        conf.Tokens = NewRandomTokenProvider(conf.TokenStore)
+    }
+    if conf.SanctionCleanupDelay <= 0 {
+        conf.SanctionCleanupDelay = defSanctionCleanupDelay
+    }
+
     s := &server {
         conf: conf,
         channels: make(map[string]ChatChannel),
-        tokens: make(map[string]*accessToken),
         running: true,
         stop: make(chan struct{}),
     }
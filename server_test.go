@@ -4,7 +4,6 @@ import (
     "strings"
     "testing"
     "time"
-    "runtime"
 )
 
 // TestAccessToken check whether the access token is correctly evicted after its
@@ -211,6 +210,10 @@ func TestConn(t *testing.T) {
     // Send a few messages and check that they arrive on both ends,
     // sequentially.
     //
+    // Each connection is read by its own goroutine, so messages sent
+    // from different connections aren't ordered among themselves. Wait
+    // for every message to arrive before sending the next one.
+    //
     // Text: Jabberwocky by Lewis Carroll.
     input := []expectedMsg {
         { conn: _c1, name: u1, msg: "Twas brillig, and the slithy toves" },
@@ -223,11 +226,7 @@ func TestConn(t *testing.T) {
         if err != nil {
             t.Errorf("Failed to send the message '%s' from %s: %+v", in.msg, in.name, err)
         }
-        // Let other goroutines execute, so we don't throttle the server
-        // and send things out of order.
-        runtime.Gosched()
-    }
-    for _, in := range input {
+
         for _, recv := range receivers {
             msg, err := recv.conn.TestRecv(time.Millisecond * 5)
             if err != nil {
@@ -263,3 +262,200 @@ func TestConn(t *testing.T) {
 
     s.Close()
 }
+
+// TestWhisper check whether whispers reach only their sender and receiver,
+// and whether whispering to a missing user notifies the sender.
+func TestWhisper(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const u3 = "user3"
+    const cn = "chan"
+
+    s := NewServerConf(GetDefaultServerConf())
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    var conns []*mockConn
+    for _, name := range []string { u1, u2, u3 } {
+        conn := NewMockConn()
+        c.ConnectUser(name, conn)
+        conns = append(conns, conn.(*mockConn))
+    }
+    // Skip every join message.
+    for _, conn := range conns {
+        for {
+            if _, err := conn.TestRecv(time.Millisecond * 5); err != nil {
+                break
+            }
+        }
+    }
+
+    c.NewWhisper("psst", u1, u2)
+    for _, conn := range conns[:2] {
+        msg, err := conn.TestRecv(time.Millisecond * 5)
+        if err != nil {
+            t.Errorf("Whisper wasn't received: %+v", err)
+        } else if want := u1 + " -> " + u2 + ": psst"; !strings.HasSuffix(msg, want) {
+            t.Errorf("Invalid whisper: expected '%s' but got '%s'", want, msg)
+        }
+    }
+    if msg, err := conns[2].TestRecv(time.Millisecond * 5); err == nil {
+        t.Errorf("Whisper was received by %s: %s", u3, msg)
+    }
+
+    c.NewWhisper("hello?", u1, "nobody")
+    if msg, err := conns[0].TestRecv(time.Millisecond * 5); err != nil {
+        t.Errorf("%s wasn't notified of the missing receiver: %+v", u1, err)
+    } else if !strings.Contains(msg, "isn't connected") {
+        t.Errorf("Invalid notification: %s", msg)
+    }
+
+    // System whispers to missing users are silently dropped.
+    c.NewSystemWhisper("hello?", "nobody")
+    c.SetRole(u1, RoleGuest)
+    c.NewWhisper("psst", u1, u2)
+    if msg, err := conns[0].TestRecv(time.Millisecond * 5); err != nil {
+        t.Errorf("%s wasn't notified of the missing permission: %+v", u1, err)
+    } else if !strings.Contains(msg, "allowed") {
+        t.Errorf("Invalid notification: %s", msg)
+    }
+    if msg, err := conns[1].TestRecv(time.Millisecond * 5); err == nil {
+        t.Errorf("Whisper from guest was received: %s", msg)
+    }
+}
+
+// TestDirect check whether direct messages reach users in other channels,
+// and whether direct channels only accept their two users.
+func TestDirect(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const u3 = "user3"
+
+    s := NewServerConf(GetDefaultServerConf())
+    defer s.Close()
+
+    err := s.SendDirect(u1, u2, "hi")
+    if err == nil {
+        t.Error("Successfully sent a message to a disconnected user")
+    } else if want, got := InvalidUser, err; want != got {
+        t.Errorf("Invalid error! Expected '%+v' but got '%+v'", want, got)
+    }
+
+    s.CreateChannel("chan")
+    c, _ := s.GetChannel("chan")
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+    c.ConnectUser(u2, c2)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    err = s.SendDirect(u1, u2, "hi")
+    if err != nil {
+        t.Errorf("Failed to send a direct message: %+v", err)
+    } else if msg, err := _c2.TestRecv(time.Millisecond * 5); err != nil {
+        t.Errorf("Direct message wasn't received: %+v", err)
+    } else if want := u1 + " -> " + u2 + ": hi"; !strings.HasSuffix(msg, want) {
+        t.Errorf("Invalid direct message: expected '%s' but got '%s'", want, msg)
+    }
+
+    // Direct messages are restricted just like any other message.
+    c.Mute(u1, 0)
+    err = s.SendDirect(u1, u2, "still here")
+    if err != nil {
+        t.Errorf("Failed to send a direct message: %+v", err)
+    } else if msg, err := _c2.TestRecv(time.Millisecond * 5); err == nil {
+        t.Errorf("Direct message from a muted user was received: %s", msg)
+    }
+    c.Unmute(u1)
+
+    name, err := s.OpenDirectChannel(u2, u1)
+    if err != nil {
+        t.Fatalf("Failed to open a direct channel: %+v", err)
+    } else if want := DirectChannelName(u1, u2); want != name {
+        t.Errorf("Invalid direct channel: expected '%s' but got '%s'", want, name)
+    }
+    if again, _ := s.OpenDirectChannel(u1, u2); again != name {
+        t.Errorf("Direct channel wasn't reused: expected '%s' but got '%s'", name, again)
+    }
+
+    for _, test := range []struct {
+        username string
+        want error
+    } {
+        { u1, nil },
+        { u2, nil },
+        { u3, UserNotAllowed },
+    } {
+        tk, _ := s.RequestToken(test.username, name)
+        err := s.Connect(tk, NewMockConn())
+        if test.want != err {
+            t.Errorf("Invalid error for %s! Expected '%+v' but got '%+v'", test.username, test.want, err)
+        }
+    }
+}
+
+// TestListChannels check whether channels are filtered and paginated.
+func TestListChannels(t *testing.T) {
+    s := NewServerConf(GetDefaultServerConf())
+    defer s.Close()
+
+    for _, name := range []string { "lobby", "games-2", "games-1", "music" } {
+        err := s.CreateChannel(name)
+        if err != nil {
+            t.Fatalf("Failed to create the channel '%s': %+v", name, err)
+        }
+    }
+    dm, _ := s.OpenDirectChannel("user1", "user2")
+
+    c, _ := s.GetChannel("games-1")
+    c.SetTopic("chess", "")
+    c.ConnectUser("user1", NewMockConn())
+    c.ConnectUser("user2", NewMockConn())
+
+    names := func(list []ChannelSummary) string {
+        var ret []string
+        for _, val := range list {
+            ret = append(ret, val.Name)
+        }
+        return strings.Join(ret, ",")
+    }
+
+    for _, test := range []struct {
+        filter ChannelFilter
+        want string
+    } {
+        { ChannelFilter {}, dm + ",games-1,games-2,lobby,music" },
+        { ChannelFilter { SkipPrivate: true }, "games-1,games-2,lobby,music" },
+        { ChannelFilter { Prefix: "games" }, "games-1,games-2" },
+        { ChannelFilter { Contains: "mes" }, "games-1,games-2" },
+        { ChannelFilter { SkipPrivate: true, Offset: 1, Limit: 2 }, "games-2,lobby" },
+        { ChannelFilter { Offset: 10 }, "" },
+    } {
+        if got := names(s.ListChannels(test.filter)); test.want != got {
+            t.Errorf("Invalid list for %+v! Expected '%s' but got '%s'", test.filter, test.want, got)
+        }
+    }
+
+    list := s.ListChannels(ChannelFilter { Prefix: "games-1" })
+    if len(list) != 1 {
+        t.Fatalf("Invalid list! Expected a single channel but got %+v", list)
+    }
+    if want, got := 2, list[0].Users; want != got {
+        t.Errorf("Invalid user count! Expected %d but got %d", want, got)
+    }
+    if want, got := "chess", list[0].Topic; want != got {
+        t.Errorf("Invalid topic! Expected '%s' but got '%s'", want, got)
+    }
+    if list[0].LastActivity.Before(list[0].Created) {
+        t.Errorf("Last activity (%+v) happened before the channel was created (%+v)", list[0].LastActivity, list[0].Created)
+    }
+    if list[0].Private {
+        t.Error("Public channel was listed as private")
+    }
+
+    c.Close()
+    if got := names(s.ListChannels(ChannelFilter { Prefix: "games" })); got != "games-2" {
+        t.Errorf("Invalid list after closing a channel! Expected 'games-2' but got '%s'", got)
+    }
+}
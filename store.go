@@ -0,0 +1,179 @@
+package go_chat_i_guess
+
+import (
+    "sync"
+    "time"
+)
+
+// StoreQuery selects which messages should be retrieved from a
+// `MessageStore`. Every non-zero field restricts the query further.
+type StoreQuery struct {
+    // Since selects only messages received after this date.
+    Since time.Time
+
+    // Until selects only messages received before this date.
+    Until time.Time
+
+    // AfterSeq selects only messages whose sequence number is greater
+    // than this value.
+    AfterSeq uint64
+
+    // Limit the query to, at most, this many of the newest matching
+    // messages.
+    Limit int
+}
+
+// match check whether `msg` is selected by the query, ignoring its
+// `Limit`.
+func (q *StoreQuery) match(msg *Message) bool {
+    if !q.Since.IsZero() && !msg.Date.After(q.Since) {
+        return false
+    } else if !q.Until.IsZero() && !msg.Date.Before(q.Until) {
+        return false
+    } else if msg.Seq <= q.AfterSeq {
+        return false
+    }
+
+    return true
+}
+
+// limit retrieve, at most, the last `q.Limit` messages in `list`.
+func (q *StoreQuery) limit(list []Message) []Message {
+    if q.Limit > 0 && len(list) > q.Limit {
+        list = list[len(list) - q.Limit:]
+    }
+
+    return list
+}
+
+// filter retrieve every message in `list` selected by the query, from
+// the oldest to the newest.
+func (q *StoreQuery) filter(list []Message) []Message {
+    var res []Message
+
+    for i := range list {
+        if q.match(&list[i]) {
+            res = append(res, list[i])
+        }
+    }
+
+    return q.limit(res)
+}
+
+// MessageStore persists the messages broadcast by every channel, so they
+// may outlive the channel itself.
+//
+// When a channel gets created, its history is loaded from the store. As
+// such, a channel that was closed (or that was lost on a restart) may be
+// reopened by simply creating it once again.
+//
+// The store may be accessed by different channels concurrently, so its
+// implementation must be properly synchronized.
+type MessageStore interface {
+    // Append `msg` to the messages stored for `channel`. Messages are
+    // appended in the order they were broadcast.
+    Append(channel string, msg Message) error
+
+    // Range retrieve the messages stored for `channel` selected by
+    // `query`, from the oldest to the newest.
+    Range(channel string, query StoreQuery) ([]Message, error)
+
+    // DeleteChannel remove every message stored for `channel`.
+    DeleteChannel(channel string) error
+}
+
+// MessageUpdater may optionally be implemented by a `MessageStore` that
+// supports replacing the messages it stored, so edited, deleted and
+// reacted messages (as well as their number of replies) are updated in
+// the store as well.
+//
+// The events that update a message aren't appended to the store, so
+// stores without this interface keep messages as they were first sent.
+type MessageUpdater interface {
+    // Update replace the message stored for `channel` whose ID is
+    // `msg.ID` with `msg`.
+    //
+    // Fails with `MessageNotFound` if there's no such message. Stores
+    // that can't cheaply check it may instead ignore the update.
+    Update(channel string, msg Message) error
+}
+
+// memoryStore keeps the messages broadcast by every channel in memory.
+type memoryStore struct {
+    // channels maps each channel's name to its messages.
+    channels map[string][]Message
+
+    // limit the number of messages kept for each channel.
+    limit int
+
+    // lock synchronizes access to `channels`.
+    lock sync.Mutex
+}
+
+// Append `msg` to the messages stored for `channel`, discarding the
+// oldest message if the channel already has too many messages.
+func (s *memoryStore) Append(channel string, msg Message) error {
+    if s.limit <= 0 {
+        return nil
+    }
+
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    list := append(s.channels[channel], msg)
+    if len(list) > s.limit {
+        // Copy the messages into a new slice, so the discarded messages
+        // may be released.
+        list = append([]Message(nil), list[len(list) - s.limit:]...)
+    }
+    s.channels[channel] = list
+
+    return nil
+}
+
+// Range retrieve the messages stored for `channel` selected by `query`.
+func (s *memoryStore) Range(channel string, query StoreQuery) ([]Message, error) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    return query.filter(s.channels[channel]), nil
+}
+
+// Update replace the message stored for `channel` whose ID is `msg.ID`.
+func (s *memoryStore) Update(channel string, msg Message) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    list := s.channels[channel]
+    for i := range list {
+        if list[i].ID == msg.ID {
+            list[i] = msg
+            return nil
+        }
+    }
+
+    return MessageNotFound
+}
+
+// DeleteChannel remove every message stored for `channel`.
+func (s *memoryStore) DeleteChannel(channel string) error {
+    s.lock.Lock()
+    delete(s.channels, channel)
+    s.lock.Unlock()
+
+    return nil
+}
+
+// NewMemoryStore create a new `MessageStore` that keeps, at most, the
+// last `limit` messages of each channel in memory.
+//
+// This store doesn't survive restarts, but it does keep the history of
+// channels that were closed while the server was running. Since these are
+// only released by `DeleteChannel`, servers that create many short-lived
+// channels should delete them once they are no longer needed.
+func NewMemoryStore(limit int) MessageStore {
+    return &memoryStore {
+        channels: make(map[string][]Message),
+        limit: limit,
+    }
+}
@@ -0,0 +1,199 @@
+package go_chat_i_guess
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "testing"
+    "time"
+)
+
+// testStore check whether `store` correctly stores and queries messages.
+func testStore(t *testing.T, store MessageStore) {
+    const cn = "chan"
+
+    base := time.Now()
+    for i := 1; i <= 5; i++ {
+        msg := Message {
+            ID: fmt.Sprintf("id-%d", i),
+            Seq: uint64(i),
+            Date: base.Add(time.Second * time.Duration(i)),
+            Message: fmt.Sprintf("message %d", i),
+            From: "user",
+        }
+
+        err := store.Append(cn, msg)
+        if err != nil {
+            t.Fatalf("Failed to store message %d: %+v", i, err)
+        }
+    }
+
+    tests := []struct {
+        query StoreQuery
+        first uint64
+        last uint64
+    } {
+        { StoreQuery {}, 1, 5 },
+        { StoreQuery { Limit: 2 }, 4, 5 },
+        { StoreQuery { AfterSeq: 3 }, 4, 5 },
+        { StoreQuery { Since: base.Add(time.Second) }, 2, 5 },
+        { StoreQuery { Until: base.Add(time.Second * 3) }, 1, 2 },
+        { StoreQuery { AfterSeq: 1, Limit: 1 }, 5, 5 },
+    }
+    for _, test := range tests {
+        list, err := store.Range(cn, test.query)
+        if err != nil {
+            t.Errorf("Failed to query %+v: %+v", test.query, err)
+        } else if want, got := int(test.last - test.first + 1), len(list); want != got {
+            t.Errorf("Invalid number of messages for %+v: expected '%d' but got '%d'", test.query, want, got)
+        } else if want, got := test.first, list[0].Seq; want != got {
+            t.Errorf("Invalid first message for %+v: expected '%d' but got '%d'", test.query, want, got)
+        } else if want, got := fmt.Sprintf("message %d", test.first), list[0].Message; want != got {
+            t.Errorf("Invalid message for %+v: expected '%s' but got '%s'", test.query, want, got)
+        }
+    }
+
+    list, err := store.Range("other", StoreQuery {})
+    if err != nil {
+        t.Errorf("Failed to query an empty channel: %+v", err)
+    } else if len(list) != 0 {
+        t.Errorf("Got messages from an empty channel: %+v", list)
+    }
+
+    if updater, ok := store.(MessageUpdater); ok {
+        // Only the last update to a message is kept.
+        for _, text := range []string { "first edit", "edited" } {
+            err = updater.Update(cn, Message { ID: "id-3", Seq: 3, Message: text, Edited: true })
+            if err != nil {
+                t.Errorf("Failed to update a message: %+v", err)
+            }
+        }
+        list, _ = store.Range(cn, StoreQuery { AfterSeq: 2, Limit: 3 })
+        if len(list) != 3 || list[0].Message != "edited" || !list[0].Edited {
+            t.Errorf("Message wasn't updated: %+v", list)
+        } else if want, got := "message 4", list[1].Message; want != got {
+            t.Errorf("Invalid message after the updated one: expected '%s' but got '%s'", want, got)
+        }
+
+        err = updater.Update(cn, Message { ID: "missing" })
+        if err != nil && err != MessageNotFound {
+            t.Errorf("Invalid error for a missing message! Expected '%+v' but got '%+v'", MessageNotFound, err)
+        }
+        list, _ = store.Range(cn, StoreQuery {})
+        if want, got := 5, len(list); want != got {
+            t.Errorf("Invalid number of messages after the updates: expected '%d' but got '%d'", want, got)
+        }
+
+        err = updater.Update("missing", Message { ID: "id-3" })
+        if err != MessageNotFound {
+            t.Errorf("Invalid error for a missing channel! Expected '%+v' but got '%+v'", MessageNotFound, err)
+        }
+    }
+
+    err = store.DeleteChannel(cn)
+    if err != nil {
+        t.Errorf("Failed to delete the channel: %+v", err)
+    }
+    list, err = store.Range(cn, StoreQuery {})
+    if err != nil {
+        t.Errorf("Failed to query a deleted channel: %+v", err)
+    } else if len(list) != 0 {
+        t.Errorf("Got messages from a deleted channel: %+v", list)
+    }
+}
+
+// TestMemoryStore check whether the in-memory store works and is bounded.
+func TestMemoryStore(t *testing.T) {
+    testStore(t, NewMemoryStore(5))
+
+    store := NewMemoryStore(2)
+    for i := 1; i <= 3; i++ {
+        store.Append("chan", Message { Seq: uint64(i) })
+    }
+    list, _ := store.Range("chan", StoreQuery {})
+    if want, got := 2, len(list); want != got {
+        t.Errorf("Invalid number of messages: expected '%d' but got '%d'", want, got)
+    } else if want, got := uint64(2), list[0].Seq; want != got {
+        t.Errorf("Invalid first message: expected '%d' but got '%d'", want, got)
+    }
+}
+
+// TestFileStore check whether the file-backed store works.
+func TestFileStore(t *testing.T) {
+    dir, err := ioutil.TempDir("", "go-chat-i-guess")
+    if err != nil {
+        t.Fatalf("Couldn't create a temporary directory: %+v", err)
+    }
+    defer os.RemoveAll(dir)
+
+    store, err := NewFileStore(dir)
+    if err != nil {
+        t.Fatalf("Couldn't create the store: %+v", err)
+    }
+
+    testStore(t, store)
+}
+
+// TestReopenChannel check whether a channel is reopened with its stored
+// history.
+func TestReopenChannel(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.Store = NewMemoryStore(conf.HistorySize)
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    err := s.CreateChannel(cn)
+    if err != nil {
+        t.Fatalf("Failed to create a channel: %+v", err)
+    }
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    err = c.ConnectUser(u1, c1)
+    if err != nil {
+        t.Fatalf("Failed to connect %s to %s: %+v", u1, cn, err)
+    }
+    _c1.TestRecv(time.Millisecond * 5)
+    _c1.TestSend("hello")
+    _c1.TestRecv(time.Millisecond * 5)
+
+    before := c.GetHistory(time.Time{}, 0)
+    c.Close()
+
+    // Reopen the channel and check that its history was restored.
+    err = s.CreateChannel(cn)
+    if err != nil {
+        t.Fatalf("Failed to reopen the channel: %+v", err)
+    }
+    c, _ = s.GetChannel(cn)
+
+    after := c.GetHistory(time.Time{}, 0)
+    if want, got := len(before), len(after); want != got {
+        t.Fatalf("Invalid reopened history: expected '%+v' but got '%+v'", before, after)
+    }
+    for i := range before {
+        if want, got := before[i].Seq, after[i].Seq; want != got {
+            t.Errorf("Invalid sequence number: expected '%d' but got '%d'", want, got)
+        } else if want, got := before[i].Message, after[i].Message; want != got {
+            t.Errorf("Invalid message: expected '%s' but got '%s'", want, got)
+        }
+    }
+
+    // Deleting the channel should remove its history.
+    err = s.DeleteChannel(cn)
+    if err != nil {
+        t.Errorf("Failed to delete the channel: %+v", err)
+    }
+    err = s.CreateChannel(cn)
+    if err != nil {
+        t.Fatalf("Failed to recreate the channel: %+v", err)
+    }
+    c, _ = s.GetChannel(cn)
+    if list := c.GetHistory(time.Time{}, 0); len(list) != 0 {
+        t.Errorf("Deleted channel still has a history: %+v", list)
+    }
+}
@@ -0,0 +1,92 @@
+package go_chat_i_guess
+
+// NewReply queue a new broadcast message from `from`, replying to the
+// message identified by `replyTo`.
+//
+// Threads aren't nested, so replying to a reply sends the message to the
+// thread of the message that was first replied to.
+//
+// Fails with `MessageNotFound` if the message isn't in the history.
+func (c *channel) NewReply(msg, from, replyTo string) error {
+    packet, err := c.reply(msg, from, replyTo)
+    if err == nil {
+        c.queue(packet)
+    }
+    return err
+}
+
+// reply create a new broadcast message from `from`, replying to the
+// message identified by `replyTo`, failing like `NewReply()`.
+func (c *channel) reply(msg, from, replyTo string) (*Message, error) {
+    parent, err := c.findMessage(replyTo)
+    if err != nil {
+        return nil, err
+    }
+
+    if len(parent.ReplyTo) > 0 {
+        replyTo = parent.ReplyTo
+        if root, ok := c.history.find(replyTo); ok {
+            parent = root
+        }
+    }
+
+    packet := c.makeMessage(KindUser, msg, from, "", map[string]string {
+        "parent_from": parent.From,
+    })
+    packet.ReplyTo = replyTo
+
+    return packet, nil
+}
+
+// GetThread retrieve a copy of the message identified by `parentID`,
+// followed by every reply to it, from the oldest to the newest.
+//
+// Only messages still kept in the channel's history are retrieved, so
+// older replies may be missing.
+//
+// Fails with `MessageNotFound` if the message isn't in the history.
+func (c *channel) GetThread(parentID string) ([]Message, error) {
+    if c.history == nil {
+        return nil, MessageNotFound
+    }
+
+    list, ok := c.history.thread(parentID)
+    if !ok {
+        return nil, MessageNotFound
+    }
+    return list, nil
+}
+
+// addReply count the reply `reply` on the message it replies to,
+// updating the channel's history and, if `updateStore` is set and the
+// store supports it, the channel's store.
+//
+// Returns a copy of the updated message, and whether it's still in the
+// history.
+//
+// This must only be called from the channel's goroutine.
+func (c *channel) addReply(reply *Message, updateStore bool) (Message, bool) {
+    if c.history == nil {
+        return Message {}, false
+    }
+
+    parent, ok := c.history.find(reply.ReplyTo)
+    if !ok {
+        return Message {}, false
+    }
+
+    parent.Replies++
+    c.rewrite(&parent, updateStore)
+
+    return parent, true
+}
+
+// threadEvent create the `KindThread` event reporting that `parent` got
+// a new reply.
+func (c *channel) threadEvent(parent *Message) *Message {
+    event := c.makeMessage(KindThread, "", "", "", map[string]string {
+        "id": parent.ID,
+    })
+    event.Replies = parent.Replies
+    return event
+}
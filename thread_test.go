@@ -0,0 +1,105 @@
+package go_chat_i_guess
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+// TestThreads check whether messages may be replied to, and whether the
+// thread's replies are counted on its first message.
+func TestThreads(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.Store = NewMemoryStore(conf.HistorySize)
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c.ConnectUser(u1, c1)
+    _c1.TestRecv(time.Millisecond * 5)
+
+    c.NewBroadcast("question?", u1)
+    _c1.TestRecv(time.Millisecond * 5)
+
+    history := c.GetHistory(time.Time{}, 1)
+    if len(history) != 1 || history[0].Message != "question?" {
+        t.Fatalf("Invalid history: %+v", history)
+    }
+    id := history[0].ID
+
+    if err := c.NewReply("answer", u1, "missing"); err != MessageNotFound {
+        t.Errorf("Invalid error for replying to a missing message! Expected '%+v' but got '%+v'", MessageNotFound, err)
+    }
+
+    if err := c.NewReply("answer", u1, id); err != nil {
+        t.Fatalf("Couldn't reply to the message: %+v", err)
+    }
+    if msg, err := _c1.TestRecv(time.Millisecond * 5); err != nil || !strings.HasSuffix(msg, u1 + " (reply to " + u1 + "): answer") {
+        t.Errorf("Invalid reply: '%s' (%+v)", msg, err)
+    }
+
+    // Replying to a reply sends the message to the same thread.
+    history = c.GetHistory(time.Time{}, 1)
+    if err := c.NewReply("follow-up", u1, history[0].ID); err != nil {
+        t.Fatalf("Couldn't reply to the reply: %+v", err)
+    }
+    _c1.TestRecv(time.Millisecond * 5)
+
+    c.NewBroadcast("unrelated", u1)
+    _c1.TestRecv(time.Millisecond * 5)
+
+    thread, err := c.GetThread(id)
+    if err != nil {
+        t.Fatalf("Couldn't retrieve the thread: %+v", err)
+    } else if len(thread) != 3 {
+        t.Fatalf("Invalid thread: %+v", thread)
+    }
+    for i, want := range []string { "question?", "answer", "follow-up" } {
+        if thread[i].Message != want {
+            t.Errorf("Invalid message %d in the thread! Expected '%s' but got '%s'", i, want, thread[i].Message)
+        } else if i > 0 && thread[i].ReplyTo != id {
+            t.Errorf("Reply %d isn't in the thread: %+v", i, thread[i])
+        }
+    }
+    if want, got := 2, thread[0].Replies; want != got {
+        t.Errorf("Invalid number of replies! Expected %d but got %d", want, got)
+    }
+
+    stored, _ := s.GetConf().Store.Range(cn, StoreQuery { Limit: 4 })
+    if len(stored) != 4 || stored[0].ID != id || stored[0].Replies != 2 {
+        t.Errorf("Number of replies wasn't stored: %+v", stored)
+    }
+
+    // The thread is updated live for the JSON protocol.
+    conf = GetDefaultServerConf()
+    conf.Controller = JSONEncoder {}
+    s2 := NewServerConf(conf)
+    defer s2.Close()
+
+    s2.CreateChannel(cn)
+    c, _ = s2.GetChannel(cn)
+
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+    c.ConnectUser(u2, c2)
+    recvEnvelope(t, _c2)
+
+    _c2.TestSend("hi")
+    parent := recvEnvelope(t, _c2)
+
+    _c2.TestSend(`{"type": "reply", "id": "` + parent.ID + `", "text": "hello"}`)
+    if env := recvEnvelope(t, _c2); env.Type != "message" || env.ReplyTo != parent.ID || env.Text != "hello" {
+        t.Errorf("Invalid reply envelope: %+v", env)
+    }
+    if env := recvEnvelope(t, _c2); env.Type != "thread" || env.Target != parent.ID || env.Replies != 1 {
+        t.Errorf("Invalid thread envelope: %+v", env)
+    }
+}
@@ -0,0 +1,200 @@
+package go_chat_i_guess
+
+import (
+    crand "crypto/rand"
+    "encoding/hex"
+    "sync"
+    "time"
+)
+
+// TokenClaims describes what is granted by a connection token.
+type TokenClaims struct {
+    // Username of the user that may connect with the token.
+    Username string
+
+    // Channel that the token gives access to.
+    Channel string
+
+    // Issued is when the token was issued.
+    Issued time.Time
+
+    // Expires is when the token stops being accepted.
+    Expires time.Time
+
+    // Uses is the number of times the token may still be consumed. If
+    // this is zero, the token may only be consumed once. If this is
+    // negative, the token may be consumed until it expires.
+    Uses int
+
+    // Options used when connecting to the channel.
+    Options ConnectOptions
+
+    // Grant proves that the channel's passphrase was supplied when the
+    // token was issued. It's empty if no passphrase was required.
+    Grant string `json:",omitempty"`
+}
+
+// expired check whether the token has expired by `now`.
+func (c *TokenClaims) expired(now time.Time) bool {
+    return now.After(c.Expires)
+}
+
+// consume decrement the number of uses left in the token, returning
+// whether it may still be used afterwards.
+func (c *TokenClaims) consume() bool {
+    if c.Uses < 0 {
+        return true
+    } else if c.Uses <= 1 {
+        c.Uses = 0
+        return false
+    }
+
+    c.Uses--
+    return true
+}
+
+// TokenOptions customizes a token requested with
+// `ChatServer.RequestTokenWithOptions`.
+type TokenOptions struct {
+    // TTL is for how long the token may be used. If this isn't positive,
+    // `ServerConf.TokenDeadline` is used instead.
+    TTL time.Duration
+
+    // Uses is the number of times the token may be used. If this is zero,
+    // the token may only be used once. If this is negative, the token may
+    // be used until it expires.
+    Uses int
+
+    // Claims are arbitrary values, like a role or a display name, handed
+    // to the channel when the user connects. See
+    // `ChatChannel.GetClaims`.
+    Claims map[string]string
+
+    // Passphrase of the channel, if it's in `AccessPassphrase` mode.
+    Passphrase string
+}
+
+// TokenProvider issues and verifies the tokens used to connect to a
+// channel.
+//
+// The provider may be accessed concurrently, so its implementation must
+// be properly synchronized.
+type TokenProvider interface {
+    // Issue a new token granting `claims`.
+    Issue(claims TokenClaims) (string, error)
+
+    // Consume `token`, retrieving its claims. A token may only be
+    // consumed as many times as allowed by its `TokenClaims.Uses`.
+    //
+    // Fails with `InvalidToken` if the token doesn't exist, if it was
+    // already consumed, if it was revoked or if it has expired.
+    Consume(token string) (TokenClaims, error)
+
+    // Revoke `token`, so it may not be consumed anymore.
+    //
+    // Fails with `InvalidToken` if the token isn't valid.
+    Revoke(token string) error
+
+    // RevokeUser revoke every token issued to `username`.
+    RevokeUser(username string) error
+
+    // Sweep release every token that has expired by `now`. The server
+    // calls this every `ServerConf.TokenCleanupDelay`.
+    Sweep(now time.Time) error
+}
+
+// randomTokens is a `TokenProvider` that issues random tokens, keeping
+// their claims in a `TokenStore` until they are consumed.
+type randomTokens struct {
+    // store where the tokens are kept.
+    store TokenStore
+
+    // lock synchronizes consuming and revoking tokens, since a token with
+    // uses left is put back into the store after being taken.
+    lock sync.Mutex
+}
+
+// NewRandomTokenProvider create a `TokenProvider` that issues random
+// tokens, generated from a cryptographically secure source and encoded
+// as hexadecimal strings, and that keeps them in `store`. If `store` is
+// nil, a store created by `NewMemoryTokenStore()` is used.
+//
+// Since the tokens are kept in the store, they may only be consumed by
+// processes sharing the same store. This is the default `TokenProvider`.
+func NewRandomTokenProvider(store TokenStore) TokenProvider {
+    if store == nil {
+        store = NewMemoryTokenStore()
+    }
+
+    return &randomTokens {
+        store: store,
+    }
+}
+
+// Issue a new random token granting `claims`.
+func (r *randomTokens) Issue(claims TokenClaims) (string, error) {
+    var randToken [32]byte
+
+    _, err := crand.Read(randToken[:])
+    if err != nil {
+        return "", err
+    }
+
+    token := hex.EncodeToString(randToken[:])
+
+    err = r.store.Put(token, claims)
+    if err != nil {
+        return "", err
+    }
+
+    return token, nil
+}
+
+// Consume `token`, removing it from the store once it has no uses left
+// or once it has expired.
+func (r *randomTokens) Consume(token string) (TokenClaims, error) {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+
+    claims, err := r.store.Take(token)
+    if err != nil {
+        return claims, err
+    } else if claims.expired(time.Now()) {
+        // The token was already taken from the store, so it won't be
+        // swept again.
+        return TokenClaims {}, InvalidToken
+    }
+
+    // The claims are retrieved as they were before being consumed.
+    next := claims
+    if next.consume() {
+        err = r.store.Put(token, next)
+        if err != nil {
+            return TokenClaims {}, err
+        }
+    }
+
+    return claims, nil
+}
+
+// Revoke `token`, removing it from the store.
+func (r *randomTokens) Revoke(token string) error {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+
+    _, err := r.store.Take(token)
+    return err
+}
+
+// RevokeUser remove every token issued to `username` from the store.
+func (r *randomTokens) RevokeUser(username string) error {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+
+    return r.store.RemoveUser(username)
+}
+
+// Sweep remove every token that has expired by `now` from the store.
+func (r *randomTokens) Sweep(now time.Time) error {
+    return r.store.Sweep(now)
+}
@@ -0,0 +1,204 @@
+package go_chat_i_guess
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// TestHMACTokenProvider check whether signed tokens may be verified by
+// any provider sharing the key, and only once.
+func TestHMACTokenProvider(t *testing.T) {
+    key := []byte("secret")
+    issuer := NewHMACTokenProvider(key)
+
+    claims := TokenClaims {
+        Username: "user",
+        Channel: "chan",
+        Expires: time.Now().Add(time.Minute),
+        Options: ConnectOptions {
+            Resume: true,
+            ResumeAfter: 42,
+        },
+    }
+    tk, err := issuer.Issue(claims)
+    if err != nil {
+        t.Fatalf("Couldn't issue the token: %+v", err)
+    }
+
+    // Tokens signed with another key must be rejected.
+    if _, err := NewHMACTokenProvider([]byte("other")).Consume(tk); err != InvalidToken {
+        t.Errorf("Invalid error for a token with another key! Expected '%+v' but got '%+v'", InvalidToken, err)
+    }
+    if _, err := issuer.Consume("x" + tk); err != InvalidToken {
+        t.Errorf("Invalid error for a tampered token! Expected '%+v' but got '%+v'", InvalidToken, err)
+    }
+
+    // Another provider with the same key (e.g., in another process) must
+    // accept the token, but only once.
+    verifier := NewHMACTokenProvider(key)
+    got, err := verifier.Consume(tk)
+    if err != nil {
+        t.Fatalf("Couldn't consume the token: %+v", err)
+    } else if got.Username != claims.Username || got.Channel != claims.Channel {
+        t.Errorf("Invalid claims: expected '%+v' but got '%+v'", claims, got)
+    } else if got.Options.Resume != claims.Options.Resume || got.Options.ResumeAfter != claims.Options.ResumeAfter {
+        t.Errorf("Invalid options: expected '%+v' but got '%+v'", claims.Options, got.Options)
+    } else if !got.Expires.Equal(claims.Expires) {
+        t.Errorf("Invalid expiration: expected '%+v' but got '%+v'", claims.Expires, got.Expires)
+    }
+    if _, err := verifier.Consume(tk); err != InvalidToken {
+        t.Errorf("Invalid error for a reused token! Expected '%+v' but got '%+v'", InvalidToken, err)
+    }
+
+    claims.Expires = time.Now().Add(-time.Second)
+    tk, _ = issuer.Issue(claims)
+    if _, err := issuer.Consume(tk); err != InvalidToken {
+        t.Errorf("Invalid error for an expired token! Expected '%+v' but got '%+v'", InvalidToken, err)
+    }
+
+    // Connect through a server using the provider.
+    conf := GetDefaultServerConf()
+    conf.Tokens = issuer
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel("chan")
+    tk, _ = s.RequestToken("user", "chan")
+    if err := s.Connect(tk, NewMockConn()); err != nil {
+        t.Errorf("Couldn't connect with a signed token: %+v", err)
+    }
+}
+
+// TestFileTokenStore check whether tokens saved to a file survive
+// reopening the store.
+func TestFileTokenStore(t *testing.T) {
+    dir, err := ioutil.TempDir("", "go-chat-i-guess")
+    if err != nil {
+        t.Fatalf("Couldn't create a temporary directory: %+v", err)
+    }
+    defer os.RemoveAll(dir)
+
+    path := filepath.Join(dir, "tokens", "tokens.json")
+
+    store, err := NewFileTokenStore(path)
+    if err != nil {
+        t.Fatalf("Couldn't create the store: %+v", err)
+    }
+
+    provider := NewRandomTokenProvider(store)
+    tk, err := provider.Issue(TokenClaims {
+        Username: "user",
+        Channel: "chan",
+        Expires: time.Now().Add(time.Minute),
+    })
+    if err != nil {
+        t.Fatalf("Couldn't issue the token: %+v", err)
+    }
+    store.Put("expired", TokenClaims {
+        Expires: time.Now().Add(-time.Minute),
+    })
+    store.Sweep(time.Now())
+
+    // Simulate a restart by reopening the store.
+    store, err = NewFileTokenStore(path)
+    if err != nil {
+        t.Fatalf("Couldn't reopen the store: %+v", err)
+    }
+    if _, err := store.Take("expired"); err != InvalidToken {
+        t.Errorf("Invalid error for a swept token! Expected '%+v' but got '%+v'", InvalidToken, err)
+    }
+
+    provider = NewRandomTokenProvider(store)
+    claims, err := provider.Consume(tk)
+    if err != nil {
+        t.Fatalf("Couldn't consume the token after reopening the store: %+v", err)
+    } else if want, got := "user", claims.Username; want != got {
+        t.Errorf("Invalid user retrieved: expected '%s' but got '%s'", want, got)
+    }
+    if _, err := provider.Consume(tk); err != InvalidToken {
+        t.Errorf("Invalid error for a reused token! Expected '%+v' but got '%+v'", InvalidToken, err)
+    }
+}
+
+// TestTokenOptions check whether tokens may be used as many times as
+// requested, whether their claims reach the channel and whether they may
+// be revoked, for every provider.
+func TestTokenOptions(t *testing.T) {
+    const cn = "chan"
+
+    providers := map[string]TokenProvider {
+        "random": NewRandomTokenProvider(nil),
+        "hmac": NewHMACTokenProvider([]byte("secret")),
+    }
+    for name, provider := range providers {
+        conf := GetDefaultServerConf()
+        conf.Tokens = provider
+        conf.SessionPolicy = SessionMulti
+        s := NewServerConf(conf)
+
+        s.CreateChannel(cn)
+        c, _ := s.GetChannel(cn)
+
+        opts := TokenOptions {
+            TTL: time.Minute,
+            Uses: 2,
+            Claims: map[string]string { "display_name": "User" },
+        }
+        tk, err := s.RequestTokenWithOptions("user", cn, opts)
+        if err != nil {
+            t.Fatalf("[%s] Couldn't generate the token: %+v", name, err)
+        }
+        for i := 0; i < 3; i++ {
+            err := s.Connect(tk, NewMockConn())
+            if i < 2 && err != nil {
+                t.Errorf("[%s] Couldn't use the token %d times: %+v", name, i + 1, err)
+            } else if i == 2 && err != InvalidToken {
+                t.Errorf("[%s] Invalid error for an exhausted token! Expected '%+v' but got '%+v'", name, InvalidToken, err)
+            }
+        }
+
+        claims, err := c.GetClaims("user")
+        if err != nil {
+            t.Errorf("[%s] Couldn't retrieve the user's claims: %+v", name, err)
+        } else if want, got := "User", claims["display_name"]; want != got {
+            t.Errorf("[%s] Invalid claim: expected '%s' but got '%s'", name, want, got)
+        }
+
+        // Reusable tokens may be used until revoked.
+        opts.Uses = -1
+        tk, _ = s.RequestTokenWithOptions("other", cn, opts)
+        for i := 0; i < 3; i++ {
+            if err := s.Connect(tk, NewMockConn()); err != nil {
+                t.Errorf("[%s] Couldn't reuse the token: %+v", name, err)
+            }
+        }
+        if err := s.RevokeToken(tk); err != nil {
+            t.Errorf("[%s] Couldn't revoke the token: %+v", name, err)
+        } else if err := s.Connect(tk, NewMockConn()); err != InvalidToken {
+            t.Errorf("[%s] Invalid error for a revoked token! Expected '%+v' but got '%+v'", name, InvalidToken, err)
+        }
+
+        // Expired tokens are rejected, even before they get swept.
+        expiring := TokenOptions {
+            TTL: time.Millisecond,
+            Uses: -1,
+        }
+        tk, _ = s.RequestTokenWithOptions("other", cn, expiring)
+        time.Sleep(time.Millisecond * 20)
+        if err := s.Connect(tk, NewMockConn()); err != InvalidToken {
+            t.Errorf("[%s] Invalid error for an expired token! Expected '%+v' but got '%+v'", name, InvalidToken, err)
+        }
+
+        tk, _ = s.RequestTokenWithOptions("other", cn, opts)
+        if err := s.RevokeTokensForUser("other"); err != nil {
+            t.Errorf("[%s] Couldn't revoke the user's tokens: %+v", name, err)
+        } else if err := s.Connect(tk, NewMockConn()); err != InvalidToken {
+            t.Errorf("[%s] Invalid error for a revoked user! Expected '%+v' but got '%+v'", name, InvalidToken, err)
+        }
+
+        s.Close()
+    }
+}
@@ -0,0 +1,221 @@
+package go_chat_i_guess
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// TokenStore keeps the claims of the tokens issued by the default
+// `TokenProvider` until they are consumed.
+//
+// The store may be accessed concurrently, so its implementation must be
+// properly synchronized.
+type TokenStore interface {
+    // Put store `claims` as the claims of `token`.
+    Put(token string, claims TokenClaims) error
+
+    // Take remove `token` from the store, retrieving its claims.
+    //
+    // Fails with `InvalidToken` if the token isn't in the store.
+    Take(token string) (TokenClaims, error)
+
+    // RemoveUser remove every token issued to `username`.
+    RemoveUser(username string) error
+
+    // Sweep remove every token that has expired by `now`.
+    Sweep(now time.Time) error
+}
+
+// memoryTokenStore keeps the tokens in a map.
+type memoryTokenStore struct {
+    // Every currently active token. The token itself is used as the map's key.
+    tokens map[string]TokenClaims
+
+    // Synchronizes access to tokens.
+    lock sync.Mutex
+}
+
+// NewMemoryTokenStore create a new `TokenStore` that keeps the tokens in
+// memory. This is the default `TokenStore`.
+func NewMemoryTokenStore() TokenStore {
+    return &memoryTokenStore {
+        tokens: make(map[string]TokenClaims),
+    }
+}
+
+// Put store `claims` as the claims of `token`.
+func (s *memoryTokenStore) Put(token string, claims TokenClaims) error {
+    s.lock.Lock()
+    s.tokens[token] = claims
+    s.lock.Unlock()
+
+    return nil
+}
+
+// Take remove `token` from the store, retrieving its claims.
+func (s *memoryTokenStore) Take(token string) (TokenClaims, error) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    claims, ok := s.tokens[token]
+    if !ok {
+        return TokenClaims {}, InvalidToken
+    }
+    delete(s.tokens, token)
+
+    return claims, nil
+}
+
+// RemoveUser remove every token issued to `username`.
+func (s *memoryTokenStore) RemoveUser(username string) error {
+    s.lock.Lock()
+    for key, val := range s.tokens {
+        if val.Username == username {
+            delete(s.tokens, key)
+        }
+    }
+    s.lock.Unlock()
+
+    return nil
+}
+
+// Sweep remove every token that has expired by `now`.
+func (s *memoryTokenStore) Sweep(now time.Time) error {
+    s.lock.Lock()
+    for key, val := range s.tokens {
+        if val.expired(now) {
+            delete(s.tokens, key)
+        }
+    }
+    s.lock.Unlock()
+
+    return nil
+}
+
+// fileTokenStore keeps the tokens in memory, saving them to a JSON file
+// on every change.
+type fileTokenStore struct {
+    // path to the file where the tokens are saved.
+    path string
+
+    // Every currently active token. The token itself is used as the map's key.
+    tokens map[string]TokenClaims
+
+    // Synchronizes access to tokens and to the file.
+    lock sync.Mutex
+}
+
+// save write every token to the store's file. The file is replaced
+// atomically, so it's never left partially written.
+//
+// Access to the store must have been properly synchronized before calling
+// this.
+func (s *fileTokenStore) save() error {
+    data, err := json.Marshal(s.tokens)
+    if err != nil {
+        return err
+    }
+
+    tmp := s.path + ".tmp"
+    err = ioutil.WriteFile(tmp, data, 0600)
+    if err != nil {
+        return err
+    }
+
+    return os.Rename(tmp, s.path)
+}
+
+// Put store `claims` as the claims of `token`.
+func (s *fileTokenStore) Put(token string, claims TokenClaims) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    s.tokens[token] = claims
+    return s.save()
+}
+
+// Take remove `token` from the store, retrieving its claims.
+func (s *fileTokenStore) Take(token string) (TokenClaims, error) {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    claims, ok := s.tokens[token]
+    if !ok {
+        return TokenClaims {}, InvalidToken
+    }
+    delete(s.tokens, token)
+
+    return claims, s.save()
+}
+
+// RemoveUser remove every token issued to `username`.
+func (s *fileTokenStore) RemoveUser(username string) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    var changed bool
+    for key, val := range s.tokens {
+        if val.Username == username {
+            delete(s.tokens, key)
+            changed = true
+        }
+    }
+
+    if !changed {
+        return nil
+    }
+    return s.save()
+}
+
+// Sweep remove every token that has expired by `now`.
+func (s *fileTokenStore) Sweep(now time.Time) error {
+    s.lock.Lock()
+    defer s.lock.Unlock()
+
+    var changed bool
+    for key, val := range s.tokens {
+        if val.expired(now) {
+            delete(s.tokens, key)
+            changed = true
+        }
+    }
+
+    if !changed {
+        return nil
+    }
+    return s.save()
+}
+
+// NewFileTokenStore create a new `TokenStore` that saves the tokens in
+// the JSON file at `path`, so they survive restarts. Tokens previously
+// saved to the file are loaded, and the file's directory is created, if
+// it doesn't exist yet.
+func NewFileTokenStore(path string) (TokenStore, error) {
+    err := os.MkdirAll(filepath.Dir(path), 0755)
+    if err != nil {
+        return nil, err
+    }
+
+    s := &fileTokenStore {
+        path: path,
+        tokens: make(map[string]TokenClaims),
+    }
+
+    data, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return s, nil
+    } else if err != nil {
+        return nil, err
+    }
+
+    err = json.Unmarshal(data, &s.tokens)
+    if err != nil {
+        return nil, err
+    }
+
+    return s, nil
+}
@@ -4,6 +4,7 @@ import (
     "io"
     "log"
     "time"
+    "sync"
     "sync/atomic"
 )
 
@@ -21,47 +22,66 @@ type Conn interface {
     SendStr(msg string) error
 }
 
-// user represent a user connected to a channel.
-type user struct {
-    // The user's name.
-    name string
+// SessionPolicy defines what happens when a user connects to a channel
+// where they are already connected.
+type SessionPolicy uint
 
-    // last time this user was sent a message from the server.
-    last time.Time
+const (
+    // Reject the new connection with `UserAlreadyConnected`.
+    SessionReject SessionPolicy = iota
+    // Accept the new connection alongside the previous ones. Messages are
+    // sent to every connection of the user.
+    SessionMulti
+    // Accept the new connection, closing every previous connection of the
+    // user after notifying them. The user doesn't leave the channel.
+    SessionTakeover
+)
 
-    // The channel to which this user is connected.
-    channel ChatChannel
+// session is a single connection of a user to a channel.
+type session struct {
+    // The user that owns this session.
+    user *user
 
     // The connection to the user's remote endpoint.
     conn Conn
 
-    // Whether the user is currently running.
+    // out queues messages to be sent to `conn` by the writer goroutine.
+    out *outbox
+
+    // Whether the session is currently running.
     running uint32
 
-    // logger used by the user to report events. If this is nil, no message
-    // shall be logged!
+    // Whether the session was kicked, and should be closed by the writer
+    // goroutine.
+    kicked uint32
+
+    // logger used by the session to report events. If this is nil, no
+    // message shall be logged!
     logger *log.Logger
 
     // Whether debug messages should be logged.
     debugLog bool
 }
 
-// isRunning check if the user is still running.
-func (u *user) isRunning() bool {
-    return atomic.LoadUint32(&u.running) == 1
+// isRunning check if the session is still running.
+func (s *session) isRunning() bool {
+    return atomic.LoadUint32(&s.running) == 1
 }
 
-// run wait for new messages from the user and forward them to the channel.
-func (u *user) run() {
-    for u.isRunning() {
-        msg, err := u.conn.Recv()
+// run wait for new messages from the remote endpoint and forward them to
+// the channel.
+func (s *session) run() {
+    u := s.user
+
+    for s.isRunning() {
+        msg, err := s.conn.Recv()
         if err != nil {
-            if u.logger != nil {
-                u.logger.Printf("[ERROR] go_chat_i_guess/user: Failed to receive the message.\n\tuser: \"%s\"\n\terror: %+v",
+            if s.logger != nil {
+                s.logger.Printf("[ERROR] go_chat_i_guess/user: Failed to receive the message.\n\tuser: \"%s\"\n\terror: %+v",
                         u.name, err)
             }
 
-            u.Close()
+            s.Close()
             return
         }
 
@@ -69,29 +89,57 @@ func (u *user) run() {
     }
 }
 
-// GetName return the user's name.
-func (u *user) GetName() string {
-    return u.name
+// write wait for messages queued for the session and send them to the
+// remote endpoint.
+//
+// If the connection fails, the session is closed and removed from its
+// user. If the session was kicked, its connection is closed once the
+// queue is empty.
+func (s *session) write() {
+    for {
+        msg, ok := s.out.pop()
+        if !ok {
+            // A kicked session is only closed after sending every queued
+            // message.
+            if atomic.LoadUint32(&s.kicked) == 1 {
+                s.conn.Close()
+            }
+            return
+        }
+
+        err := s.conn.SendStr(msg)
+        if err != nil {
+            s.user.channel.dropSession(s, err)
+            return
+        }
+    }
 }
 
-// SendStr a new, formatted, message to the user.
-func (u *user) SendStr(msg string) error {
-    return u.conn.SendStr(msg)
+// SendStr queue a new, formatted, message to the session. This never
+// blocks waiting for the message to be sent.
+func (s *session) SendStr(msg string) error {
+    return s.out.push(msg, false)
 }
 
-// Close the user's connection and any other resource, like its handling
-// goroutine.
+// replay queue a previously sent message to the session, regardless of
+// whether the session's queue is full.
+func (s *session) replay(msg string) error {
+    return s.out.push(msg, true)
+}
+
+// Close the session's connection and its writer goroutine.
 //
 // This can safely be called multiple times (and from multiple goroutines),
 // as it will only run on the first call.
-func (u *user) Close() error {
-    if atomic.CompareAndSwapUint32(&u.running, 1, 0) {
-        if u.debugLog && u.logger != nil {
-            u.logger.Printf("[DEBUG] go_chat_i_guess/user: Closing connection...\n\tuser: \"%s\"",
-                    u.name)
+func (s *session) Close() error {
+    if atomic.CompareAndSwapUint32(&s.running, 1, 0) {
+        if s.debugLog && s.logger != nil {
+            s.logger.Printf("[DEBUG] go_chat_i_guess/user: Closing connection...\n\tuser: \"%s\"",
+                    s.user.name)
         }
 
-        u.conn.Close()
+        s.out.close()
+        s.conn.Close()
     }
 
     return nil
@@ -102,53 +150,231 @@ func (u *user) Close() error {
 //
 // This is useful when the server (HTTP, TCP etc) that is running the Chat
 // Server already spawns a new goroutine for each received connection. In
-// this scenario, instead of calling `newUserBg()` and spawning yet another
-// goroutine, it's possible to call `newUser()` followed by `RunAndWait()`.
+// this scenario, instead of calling `RunBg()` and spawning yet another
+// goroutine, it's possible to call `RunAndWait()` directly.
 //
-// The calling `user` will be closed when this function returns.
-func (u *user) RunAndWait() {
-    defer u.Close()
+// The calling `session` will be closed when this function returns.
+func (s *session) RunAndWait() {
+    defer s.Close()
+
+    s.run()
+}
+
+// RunBg handle requests sent from the remote client in a new goroutine,
+// forwarding those message to the channel. To stop this goroutine and
+// clean up its resources, call `s.Close()`.
+func (s *session) RunBg() {
+    go s.run()
+}
+
+// kick send `notice` to the remote endpoint and close the session, as
+// soon as every message queued before it gets sent. If `notice` is empty,
+// only the queued messages are sent.
+func (s *session) kick(notice string) {
+    if atomic.CompareAndSwapUint32(&s.running, 1, 0) {
+        atomic.StoreUint32(&s.kicked, 1)
+
+        if len(notice) > 0 {
+            s.out.push(notice, true)
+        }
+        s.out.drain()
+    }
+}
+
+// discard release the session's resources without closing its
+// connection, for a session that never got added to its channel.
+func (s *session) discard() {
+    if atomic.CompareAndSwapUint32(&s.running, 1, 0) {
+        s.out.close()
+    }
+}
+
+// user represent a user connected to a channel, through one or more
+// sessions.
+type user struct {
+    // The user's name.
+    name string
+
+    // last time this user was sent a message from the server.
+    last time.Time
+
+    // The channel to which this user is connected.
+    channel *channel
+
+    // sessions currently open by the user.
+    sessions []*session
+
+    // claims supplied by the user's latest session.
+    claims map[string]string
+
+    // lock synchronizes access to `sessions` and to `claims`.
+    lock sync.Mutex
+
+    // logger used by the user to report events. If this is nil, no message
+    // shall be logged!
+    logger *log.Logger
+
+    // Whether debug messages should be logged.
+    debugLog bool
+}
 
-    u.run()
+// GetName return the user's name.
+func (u *user) GetName() string {
+    return u.name
 }
 
-// newUserBg create a new user named `name`, connected to `channel` and
-// receiving and sending messages to `conn`.
+// copyMap retrieve a copy of `m`.
+func copyMap(m map[string]string) map[string]string {
+    if m == nil {
+        return nil
+    }
+
+    cp := make(map[string]string, len(m))
+    for k, v := range m {
+        cp[k] = v
+    }
+    return cp
+}
+
+// setClaims replace the user's claims with a copy of `claims`.
+func (u *user) setClaims(claims map[string]string) {
+    cp := copyMap(claims)
+
+    u.lock.Lock()
+    u.claims = cp
+    u.lock.Unlock()
+}
+
+// getClaims retrieve a copy of the user's claims.
+func (u *user) getClaims() map[string]string {
+    u.lock.Lock()
+    defer u.lock.Unlock()
+
+    return copyMap(u.claims)
+}
+
+// addSession open a new session for the user, communicating through
+// `conn`.
 //
-// `newUser()` executes a new goroutine to handle messages received by the
-// user, forwarding those message to the channel. To stop this goroutine
-// and clean up its resources, call `c.Close()`.
+// The session executes a new goroutine to send messages queued for the
+// user. This goroutine is stopped when the session gets `Close()`d.
 //
-// If `channel` or `conn` is nil, then this function will panic!
-func newUserBg(name string, channel ChatChannel, conn Conn,
-        logger *log.Logger, debugLog bool) *user {
+// If `conn` is nil, then this function will panic!
+func (u *user) addSession(conn Conn) *session {
+    if conn == nil {
+        panic("go_chat_i_guess/user addSession: nil conn")
+    }
 
-    if channel == nil {
-        panic("go_chat_i_guess/user newUserBg: nil channel")
-    } else if conn == nil {
-        panic("go_chat_i_guess/user newUserBg: nil conn")
+    s := &session {
+        user: u,
+        conn: conn,
+        out: newOutbox(u.channel.sendQueueSize, u.channel.sendQueuePolicy),
+        running: 1,
+        logger: u.logger,
+        debugLog: u.debugLog,
     }
 
-    u := newUser(name, channel, conn, logger, debugLog)
+    u.lock.Lock()
+    u.sessions = append(u.sessions, s)
+    u.lock.Unlock()
+
+    go s.write()
 
-    go u.run()
+    return s
+}
+
+// removeSession remove `s` from the user's sessions, returning whether
+// it was the user's last session.
+func (u *user) removeSession(s *session) bool {
+    u.lock.Lock()
+    defer u.lock.Unlock()
 
-    return u
+    for i := range u.sessions {
+        if u.sessions[i] == s {
+            u.sessions = append(u.sessions[:i], u.sessions[i+1:]...)
+            return len(u.sessions) == 0
+        }
+    }
+
+    return false
 }
 
-// newUser create a new user named `name`, connected to `channel` and
-// receiving and sending messages to `conn`.
+// takeSessions remove every session from the user, and retrieve them.
+func (u *user) takeSessions() []*session {
+    u.lock.Lock()
+    list := u.sessions
+    u.sessions = nil
+    u.lock.Unlock()
+
+    return list
+}
+
+// kick close every session of the user, after sending them `notice`.
+func (u *user) kick(notice string) {
+    for _, s := range u.takeSessions() {
+        s.kick(notice)
+    }
+}
+
+// SendStr queue a new, formatted, message to every session of the user.
+// This never blocks waiting for the message to be sent.
+//
+// Sessions that fail to queue the message (because they are closed or,
+// depending on the channel's `OverflowPolicy`, because their queue is
+// full) get closed. The call only fails if every session failed.
+func (u *user) SendStr(msg string) error {
+    u.lock.Lock()
+    defer u.lock.Unlock()
+
+    var err error = ConnEOF
+    var list []*session
+    for _, s := range u.sessions {
+        if e := s.SendStr(msg); e != nil {
+            if u.debugLog && u.logger != nil {
+                u.logger.Printf("[DEBUG] go_chat_i_guess/user: Closing session that failed to queue a message...\n\tuser: \"%s\"\n\terror: %+v",
+                        u.name, e)
+            }
+
+            s.Close()
+            err = e
+        } else {
+            list = append(list, s)
+        }
+    }
+    u.sessions = list
+
+    if len(list) == 0 {
+        return err
+    }
+    return nil
+}
+
+// Close every session of the user.
+//
+// This can safely be called multiple times (and from multiple goroutines).
+func (u *user) Close() error {
+    for _, s := range u.takeSessions() {
+        s.Close()
+    }
+
+    return nil
+}
+
+// newUser create a new user named `name`, connected to `channel`, without
+// any session.
 //
-// If `channel` or `conn` is nil, then this function will panic!
-func newUser(name string, channel ChatChannel, conn Conn,
-        logger *log.Logger, debugLog bool) *user {
+// If `channel` is nil, then this function will panic!
+func newUser(name string, channel *channel, logger *log.Logger,
+        debugLog bool) *user {
+
+    if channel == nil {
+        panic("go_chat_i_guess/user newUser: nil channel")
+    }
 
     return &user {
         name: name,
         last: time.Now(),
         channel: channel,
-        conn: conn,
-        running: 1,
         logger: logger,
         debugLog: debugLog,
     }
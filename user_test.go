@@ -0,0 +1,252 @@
+package go_chat_i_guess
+
+import (
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// TestMultiSession check whether a user may connect more than once to a
+// channel, receiving every message in each session, and whether the join
+// and leave events are only sent for the first and last sessions.
+func TestMultiSession(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.SessionPolicy = SessionMulti
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+    c.ConnectUser(u2, c2)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    var sessions []*mockConn
+    for i := 0; i < 2; i++ {
+        conn := NewMockConn()
+        err := c.ConnectUser(u1, conn)
+        if err != nil {
+            t.Fatalf("Failed to open session %d: %+v", i, err)
+        }
+        sessions = append(sessions, conn.(*mockConn))
+    }
+
+    if msg, err := _c2.TestRecv(time.Millisecond * 5); err != nil || !strings.Contains(msg, "entered") {
+        t.Errorf("%s didn't receive the join message: %s, %+v", u2, msg, err)
+    }
+    if msg, err := _c2.TestRecv(time.Millisecond * 5); err == nil {
+        t.Errorf("Join message was sent for the second session: %s", msg)
+    }
+    // The join message may reach either session, depending on timing.
+    for _, conn := range sessions {
+        conn.TestRecv(time.Millisecond * 5)
+    }
+
+    // Broadcasts and whispers must reach every session.
+    c.NewBroadcast("hello", u2)
+    c.NewWhisper("psst", u2, u1)
+    for i, conn := range sessions {
+        for _, want := range []string { "hello", "psst" } {
+            msg, err := conn.TestRecv(time.Millisecond * 5)
+            if err != nil || !strings.HasSuffix(msg, want) {
+                t.Errorf("Session %d didn't receive '%s': %s, %+v", i, want, msg, err)
+            }
+        }
+    }
+    _c2.TestRecv(time.Millisecond * 5)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    // Closing a single session shouldn't remove the user.
+    sessions[0].Close()
+    time.Sleep(time.Millisecond * 5)
+    c.NewBroadcast("still there?", u2)
+    if msg, err := sessions[1].TestRecv(time.Millisecond * 5); err != nil {
+        t.Errorf("Remaining session didn't receive the message: %+v", err)
+    } else if !strings.HasSuffix(msg, "still there?") {
+        t.Errorf("Invalid message: %s", msg)
+    }
+    _c2.TestRecv(time.Millisecond * 5)
+    if msg, err := _c2.TestRecv(time.Millisecond * 5); err == nil {
+        t.Errorf("Leave message was sent while a session was open: %s", msg)
+    }
+
+    // Closing the last session should.
+    sessions[1].Close()
+    time.Sleep(time.Millisecond * 5)
+    c.NewBroadcast("anyone?", u2)
+    _c2.TestRecv(time.Millisecond * 5)
+    if msg, err := _c2.TestRecv(time.Millisecond * 5); err != nil || !strings.Contains(msg, "exited") {
+        t.Errorf("%s didn't receive the leave message: %s, %+v", u2, msg, err)
+    }
+}
+
+// TestTakeover check whether a new connection replaces the previous one,
+// notifying it, without sending any join or leave message.
+func TestTakeover(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.SessionPolicy = SessionTakeover
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+    c.ConnectUser(u2, c2)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    old := NewMockConn()
+    _old := old.(*mockConn)
+    c.ConnectUser(u1, old)
+    _old.TestRecv(time.Millisecond * 5)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    conn := NewMockConn()
+    _conn := conn.(*mockConn)
+    err := c.ConnectUser(u1, conn)
+    if err != nil {
+        t.Fatalf("Failed to take over the session: %+v", err)
+    }
+
+    // The previous connection may already be closed, so read the notice
+    // directly from its queue.
+    time.Sleep(time.Millisecond * 5)
+    select {
+    case msg := <-_old.fromServer:
+        if !strings.Contains(msg, "another session") {
+            t.Errorf("Invalid notice: %s", msg)
+        }
+    default:
+        t.Error("Previous session wasn't notified")
+    }
+    if !_old.isClosed() {
+        t.Error("Previous session wasn't closed")
+    }
+
+    c.NewBroadcast("hello", u2)
+    if msg, err := _conn.TestRecv(time.Millisecond * 5); err != nil || !strings.HasSuffix(msg, "hello") {
+        t.Errorf("New session didn't receive the message: %s, %+v", msg, err)
+    }
+    _c2.TestRecv(time.Millisecond * 5)
+    if msg, err := _c2.TestRecv(time.Millisecond * 5); err == nil {
+        t.Errorf("Takeover sent a spurious message: %s", msg)
+    }
+    if list := c.GetUsers(nil); len(list) != 2 {
+        t.Errorf("Invalid list of users: %+v", list)
+    }
+}
+
+// exclusiveEncoder is a `MessageEncoderV2` that reports whether it was
+// ever called concurrently, encoding every message with `next`.
+type exclusiveEncoder struct {
+    next MessageEncoderV2
+
+    // calls currently encoding a message.
+    calls int32
+
+    // concurrent is set if more than one message was encoded at once.
+    concurrent int32
+}
+
+func (e *exclusiveEncoder) EncodeMessage(channel ChatChannel, msg *Message) string {
+    if atomic.AddInt32(&e.calls, 1) > 1 {
+        atomic.StoreInt32(&e.concurrent, 1)
+    }
+    defer atomic.AddInt32(&e.calls, -1)
+
+    return e.next.EncodeMessage(channel, msg)
+}
+
+// TestTakeoverBusyChannel check whether the notice sent to a session that
+// was taken over is encoded by the channel's goroutine.
+func TestTakeoverBusyChannel(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+
+    gate := make(chan struct{})
+    enc := &exclusiveEncoder { next: gatedEncoder { nil, gate } }
+
+    conf := GetDefaultServerConf()
+    conf.SessionPolicy = SessionTakeover
+    conf.Encoder = enc
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    old := NewMockConn()
+    _old := old.(*mockConn)
+    c.ConnectUser(u1, old)
+    _old.TestRecv(time.Millisecond * 5)
+
+    // Take over the session while the channel is encoding a message.
+    c.NewSystemBroadcast("gate")
+    time.Sleep(time.Millisecond * 5)
+    err := c.ConnectUser(u1, NewMockConn())
+    if err != nil {
+        t.Fatalf("Failed to take over the session: %+v", err)
+    }
+    time.Sleep(time.Millisecond * 5)
+    close(gate)
+
+    time.Sleep(time.Millisecond * 5)
+    if atomic.LoadInt32(&enc.concurrent) != 0 {
+        t.Error("The notice was encoded concurrently with another message")
+    }
+
+    var notified bool
+    for len(_old.fromServer) > 0 {
+        notified = strings.Contains(<-_old.fromServer, "another session")
+    }
+    if !notified || !_old.isClosed() {
+        t.Error("Previous session wasn't notified and closed")
+    }
+}
+
+// TestRejoin check whether a user leaving is reported before the user
+// joins the channel again.
+func TestRejoin(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const cn = "chan"
+
+    s := NewServerConf(GetDefaultServerConf())
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+    c.ConnectUser(u2, c2)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    for i := 0; i < 16; i++ {
+        c.ConnectUser(u1, NewMockConn())
+        _c2.TestRecv(time.Millisecond * 5)
+
+        c.RemoveUser(u1)
+        c.ConnectUser(u1, NewMockConn())
+        for _, want := range []string { "exited", "entered" } {
+            if msg, err := _c2.TestRecv(time.Millisecond * 5); err != nil || !strings.Contains(msg, u1 + " " + want) {
+                t.Fatalf("Expected %s to have %s the channel, but got '%s' (%+v)", u1, want, msg, err)
+            }
+        }
+
+        c.RemoveUser(u1)
+        _c2.TestRecv(time.Millisecond * 5)
+    }
+}
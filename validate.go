@@ -0,0 +1,392 @@
+package go_chat_i_guess
+
+import (
+    "errors"
+    "fmt"
+    "regexp"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// ValidationAction defines what a `Validator` does with a message that
+// breaks its rule.
+type ValidationAction uint
+
+const (
+    // Refuse the message, reporting why to its sender.
+    ValidationReject ValidationAction = iota
+    // Remove the offending content from the message (for example,
+    // truncating it to the maximum length).
+    ValidationTrim
+    // Replace the offending content (for example, masking a forbidden
+    // word with asterisks).
+    ValidationReplace
+)
+
+func (a ValidationAction) String() string {
+    switch a {
+    case ValidationReject:
+        return "reject"
+    case ValidationTrim:
+        return "trim"
+    case ValidationReplace:
+        return "replace"
+    default:
+        return "unknown"
+    }
+}
+
+// ValidationError reports why a message was refused by a `Validator`.
+type ValidationError struct {
+    // Rule that refused the message (for example, "max-bytes").
+    Rule string
+
+    // Reason why the message was refused, as reported to its sender.
+    Reason string
+}
+
+func (e *ValidationError) Error() string {
+    if len(e.Reason) == 0 {
+        return InvalidMessage.Error()
+    }
+    return InvalidMessage.Error() + ": " + e.Reason
+}
+
+// Unwrap retrieve `InvalidMessage`, so `errors.Is(err, InvalidMessage)`
+// works for every `ValidationError`.
+func (e *ValidationError) Unwrap() error {
+    return InvalidMessage
+}
+
+// Validator checks, and optionally modifies, the text of the messages
+// sent by users.
+//
+// Messages (broadcasts, whispers, direct messages and edits) are
+// validated as they were sent, before being encoded. Encoders that derive
+// some other text from a message (for example, the text of a
+// `JSONCommand` or a new topic) validate it through
+// `ChatChannel.Validate()` before using it. Text that gets sent as a new
+// message (like a whisper sent by a command) is validated once that
+// message is handled.
+//
+// Validators are configured through `ServerConf.Validators`, and are
+// called from the channel's goroutine, in the order they were supplied.
+// Each validator receives the text returned by the previous one.
+type Validator interface {
+    // Validate check `text`, retrieving the (possibly modified) text that
+    // should be sent instead. Returning an error refuses the message, and
+    // the error is whispered back to its sender. `ValidationError` may be
+    // used to report why the message was refused.
+    Validate(text string) (string, error)
+}
+
+// ValidatorFunc is a function that implements `Validator`.
+type ValidatorFunc func(text string) (string, error)
+
+// Validate call `f`.
+func (f ValidatorFunc) Validate(text string) (string, error) {
+    return f(text)
+}
+
+// ellipsis appended to messages truncated by `ValidationReplace`.
+const ellipsis = "…"
+
+// truncateBytes truncate `text` to at most `limit` bytes, without
+// splitting any rune.
+func truncateBytes(text string, limit int) string {
+    if len(text) <= limit {
+        return text
+    }
+
+    for limit > 0 && !utf8.RuneStart(text[limit]) {
+        limit--
+    }
+    return text[:limit]
+}
+
+// truncateRunes truncate `text` to at most `limit` runes.
+func truncateRunes(text string, limit int) string {
+    var count int
+    for idx := range text {
+        if count == limit {
+            return text[:idx]
+        }
+        count++
+    }
+    return text
+}
+
+// maxBytes limits the length of messages in bytes.
+type maxBytes struct {
+    limit int
+    action ValidationAction
+}
+
+// NewMaxBytesValidator create a `Validator` for messages longer than
+// `limit` bytes. `ValidationTrim` truncates the message to the limit, and
+// `ValidationReplace` also ends it with an ellipsis.
+func NewMaxBytesValidator(limit int, action ValidationAction) Validator {
+    return &maxBytes {
+        limit: limit,
+        action: action,
+    }
+}
+
+// Validate check the length of `text`, in bytes.
+func (v *maxBytes) Validate(text string) (string, error) {
+    if len(text) <= v.limit {
+        return text, nil
+    }
+
+    switch v.action {
+    case ValidationTrim:
+        return truncateBytes(text, v.limit), nil
+    case ValidationReplace:
+        if v.limit < len(ellipsis) {
+            return truncateBytes(text, v.limit), nil
+        }
+        return truncateBytes(text, v.limit - len(ellipsis)) + ellipsis, nil
+    default:
+        return "", &ValidationError {
+            Rule: "max-bytes",
+            Reason: fmt.Sprintf("it's longer than %d bytes", v.limit),
+        }
+    }
+}
+
+// maxRunes limits the length of messages in runes.
+type maxRunes struct {
+    limit int
+    action ValidationAction
+}
+
+// NewMaxRunesValidator create a `Validator` for messages longer than
+// `limit` runes (i.e., characters). `ValidationTrim` truncates the
+// message to the limit, and `ValidationReplace` also ends it with an
+// ellipsis.
+func NewMaxRunesValidator(limit int, action ValidationAction) Validator {
+    return &maxRunes {
+        limit: limit,
+        action: action,
+    }
+}
+
+// Validate check the length of `text`, in runes.
+func (v *maxRunes) Validate(text string) (string, error) {
+    if utf8.RuneCountInString(text) <= v.limit {
+        return text, nil
+    }
+
+    switch v.action {
+    case ValidationTrim:
+        return truncateRunes(text, v.limit), nil
+    case ValidationReplace:
+        if v.limit < 1 {
+            return "", nil
+        }
+        return truncateRunes(text, v.limit - 1) + ellipsis, nil
+    default:
+        return "", &ValidationError {
+            Rule: "max-runes",
+            Reason: fmt.Sprintf("it's longer than %d characters", v.limit),
+        }
+    }
+}
+
+// validUTF8 checks whether messages are valid UTF-8.
+type validUTF8 struct {
+    action ValidationAction
+}
+
+// NewUTF8Validator create a `Validator` for messages that aren't valid
+// UTF-8. `ValidationTrim` removes the invalid bytes, and
+// `ValidationReplace` replaces them with U+FFFD.
+func NewUTF8Validator(action ValidationAction) Validator {
+    return &validUTF8 {
+        action: action,
+    }
+}
+
+// Validate check whether `text` is valid UTF-8.
+func (v *validUTF8) Validate(text string) (string, error) {
+    if utf8.ValidString(text) {
+        return text, nil
+    }
+
+    switch v.action {
+    case ValidationTrim:
+        return strings.ToValidUTF8(text, ""), nil
+    case ValidationReplace:
+        return strings.ToValidUTF8(text, string(utf8.RuneError)), nil
+    default:
+        return "", &ValidationError {
+            Rule: "utf8",
+            Reason: "it isn't valid UTF-8",
+        }
+    }
+}
+
+// control checks whether messages have control characters.
+type control struct {
+    action ValidationAction
+}
+
+// NewControlValidator create a `Validator` for messages with control
+// characters, other than line breaks and tabs. `ValidationTrim` removes
+// the control characters, and `ValidationReplace` replaces them with
+// spaces.
+func NewControlValidator(action ValidationAction) Validator {
+    return &control {
+        action: action,
+    }
+}
+
+// isControl check whether `r` is a forbidden control character.
+func isControl(r rune) bool {
+    return unicode.IsControl(r) && r != '\n' && r != '\t'
+}
+
+// Validate check whether `text` has any control character.
+func (v *control) Validate(text string) (string, error) {
+    if strings.IndexFunc(text, isControl) == -1 {
+        return text, nil
+    }
+
+    switch v.action {
+    case ValidationTrim:
+        return strings.Map(func(r rune) rune {
+            if isControl(r) {
+                return -1
+            }
+            return r
+        }, text), nil
+    case ValidationReplace:
+        return strings.Map(func(r rune) rune {
+            if isControl(r) {
+                return ' '
+            }
+            return r
+        }, text), nil
+    default:
+        return "", &ValidationError {
+            Rule: "control",
+            Reason: "it contains control characters",
+        }
+    }
+}
+
+// filter checks whether messages match a regular expression.
+type filter struct {
+    rule string
+    re *regexp.Regexp
+    action ValidationAction
+}
+
+// NewRegexpValidator create a `Validator` for messages matching `re`.
+// `ValidationTrim` removes every match, and `ValidationReplace` masks
+// every match with asterisks.
+func NewRegexpValidator(re *regexp.Regexp, action ValidationAction) Validator {
+    return &filter {
+        rule: "regexp",
+        re: re,
+        action: action,
+    }
+}
+
+// NewBlocklistValidator create a `Validator` for messages with any of the
+// `words`, which are matched as whole words, ignoring their case.
+// `ValidationTrim` removes the words, and `ValidationReplace` masks them
+// with asterisks.
+//
+// If `words` is empty, then this function will panic!
+func NewBlocklistValidator(words []string, action ValidationAction) Validator {
+    if len(words) == 0 {
+        panic("go_chat_i_guess/validate NewBlocklistValidator: empty blocklist")
+    }
+
+    quoted := make([]string, 0, len(words))
+    for _, word := range words {
+        quoted = append(quoted, regexp.QuoteMeta(word))
+    }
+
+    return &filter {
+        rule: "blocklist",
+        re: regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`),
+        action: action,
+    }
+}
+
+// Validate check whether `text` matches the filter.
+func (v *filter) Validate(text string) (string, error) {
+    if !v.re.MatchString(text) {
+        return text, nil
+    }
+
+    switch v.action {
+    case ValidationTrim:
+        return v.re.ReplaceAllString(text, ""), nil
+    case ValidationReplace:
+        return v.re.ReplaceAllStringFunc(text, func(match string) string {
+            return strings.Repeat("*", utf8.RuneCountInString(match))
+        }), nil
+    default:
+        return "", &ValidationError {
+            Rule: v.rule,
+            Reason: "it contains forbidden content",
+        }
+    }
+}
+
+// Validate run `text` through every validator of the channel, in order,
+// retrieving the validated text.
+//
+// Fails if any validator refuses the text, or if nothing is left of a
+// non-empty text after validating it.
+func (c *channel) Validate(text string) (string, error) {
+    res := text
+    for _, v := range c.validators {
+        var err error
+
+        res, err = v.Validate(res)
+        if err != nil {
+            return "", err
+        }
+    }
+
+    if len(res) == 0 && len(text) > 0 {
+        return "", &ValidationError {
+            Rule: "empty",
+            Reason: "nothing was left after validating it",
+        }
+    }
+
+    return res, nil
+}
+
+// validate run the text of the user message `msg` through every
+// validator of the channel, updating it with the validated text.
+func (c *channel) validate(msg *Message) error {
+    text, err := c.Validate(msg.Message)
+    if err != nil {
+        return err
+    }
+
+    msg.Message = text
+    return nil
+}
+
+// refuse whisper to the sender of `msg` why it was refused.
+//
+// This must only be called from the channel's goroutine.
+func (c *channel) refuse(msg *Message, err error) {
+    reason := err.Error()
+
+    var verr *ValidationError
+    if errors.As(err, &verr) && len(verr.Reason) > 0 {
+        reason = verr.Reason
+    }
+
+    c.whisperNow("Your message wasn't sent to " + c.name + " because " + reason + ".",
+            msg.From)
+}
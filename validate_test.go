@@ -0,0 +1,191 @@
+package go_chat_i_guess
+
+import (
+    "errors"
+    "regexp"
+    "strings"
+    "testing"
+    "time"
+)
+
+// TestValidators check every built-in validator, with every action.
+func TestValidators(t *testing.T) {
+    re := regexp.MustCompile(`[0-9]+`)
+
+    for i, test := range []struct {
+        v Validator
+        text string
+        want string
+        rule string
+    } {
+        { NewMaxBytesValidator(5, ValidationReject), "hello", "hello", "" },
+        { NewMaxBytesValidator(5, ValidationReject), "hello!", "", "max-bytes" },
+        { NewMaxBytesValidator(5, ValidationTrim), "olá olá", "olá ", "" },
+        { NewMaxBytesValidator(5, ValidationTrim), "oláá", "olá", "" },
+        { NewMaxBytesValidator(6, ValidationReplace), "hello world", "hel…", "" },
+        { NewMaxRunesValidator(5, ValidationReject), "olá olá", "", "max-runes" },
+        { NewMaxRunesValidator(5, ValidationTrim), "olá olá", "olá o", "" },
+        { NewMaxRunesValidator(5, ValidationReplace), "olá olá", "olá …", "" },
+        { NewUTF8Validator(ValidationReject), "a\xffb", "", "utf8" },
+        { NewUTF8Validator(ValidationTrim), "a\xffb", "ab", "" },
+        { NewUTF8Validator(ValidationReplace), "a\xffb", "a�b", "" },
+        { NewControlValidator(ValidationReject), "a\x1bb", "", "control" },
+        { NewControlValidator(ValidationReject), "a\n\tb", "a\n\tb", "" },
+        { NewControlValidator(ValidationTrim), "a\x1b\x07b", "ab", "" },
+        { NewControlValidator(ValidationReplace), "a\x1bb", "a b", "" },
+        { NewBlocklistValidator([]string { "darn" }, ValidationReject), "Darn it", "", "blocklist" },
+        { NewBlocklistValidator([]string { "darn" }, ValidationReject), "darned", "darned", "" },
+        { NewBlocklistValidator([]string { "darn", "heck" }, ValidationTrim), "darn, heck", ", ", "" },
+        { NewBlocklistValidator([]string { "darn" }, ValidationReplace), "oh DARN", "oh ****", "" },
+        { NewRegexpValidator(re, ValidationReject), "call 555", "", "regexp" },
+        { NewRegexpValidator(re, ValidationReplace), "call 555", "call ***", "" },
+    } {
+        got, err := test.v.Validate(test.text)
+
+        var verr *ValidationError
+        if len(test.rule) > 0 {
+            if !errors.As(err, &verr) || verr.Rule != test.rule {
+                t.Errorf("Invalid error for test %d! Expected rule '%s' but got %+v", i, test.rule, err)
+            } else if !errors.Is(err, InvalidMessage) {
+                t.Errorf("Error for test %d isn't an InvalidMessage: %+v", i, err)
+            }
+        } else if err != nil {
+            t.Errorf("Test %d failed: %+v", i, err)
+        } else if test.want != got {
+            t.Errorf("Invalid text for test %d! Expected '%q' but got '%q'", i, test.want, got)
+        }
+    }
+}
+
+// TestValidation check whether channels validate their messages, and
+// whether senders are told why their messages were refused.
+func TestValidation(t *testing.T) {
+    const u1 = "user1"
+    const u2 = "user2"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.Validators = []Validator {
+        NewControlValidator(ValidationTrim),
+        NewMaxRunesValidator(10, ValidationReject),
+    }
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c.ConnectUser(u1, c1)
+    _c1.TestRecv(time.Millisecond * 5)
+
+    c2 := NewMockConn()
+    _c2 := c2.(*mockConn)
+    c.ConnectUser(u2, c2)
+    _c1.TestRecv(time.Millisecond * 5)
+    _c2.TestRecv(time.Millisecond * 5)
+
+    c.NewBroadcast("a very long message", u1)
+    if msg, err := _c1.TestRecv(time.Millisecond * 5); err != nil || !strings.Contains(msg, "longer than 10 characters") {
+        t.Errorf("Sender wasn't told why the message was refused: '%s' (%+v)", msg, err)
+    }
+
+    c.NewBroadcast("hi\x1b[2J", u1)
+    if msg, err := _c2.TestRecv(time.Millisecond * 5); err != nil || !strings.HasSuffix(msg, ": hi[2J") {
+        t.Errorf("Invalid validated message: '%s' (%+v)", msg, err)
+    }
+
+    _c1.TestRecv(time.Millisecond * 5)
+
+    // Replies and edits are validated just like any other message.
+    id := c.GetHistory(time.Time{}, 1)[0].ID
+    c.NewReply("a very long reply", u1, id)
+    if msg, err := _c1.TestRecv(time.Millisecond * 5); err != nil || !strings.Contains(msg, "longer than 10 characters") {
+        t.Errorf("Reply wasn't validated: '%s' (%+v)", msg, err)
+    }
+    c.EditMessage(id, u1, "a very long edit")
+    if msg, err := _c1.TestRecv(time.Millisecond * 5); err != nil || !strings.Contains(msg, "longer than 10 characters") {
+        t.Errorf("Edit wasn't validated: '%s' (%+v)", msg, err)
+    }
+    if msg, err := _c2.TestRecv(time.Millisecond * 5); err == nil {
+        t.Errorf("Invalid message was broadcast: '%s'", msg)
+    }
+
+    // System messages aren't validated.
+    c.NewSystemWhisper("a very long notice", u2)
+    if msg, err := _c2.TestRecv(time.Millisecond * 5); err != nil || !strings.HasSuffix(msg, "a very long notice") {
+        t.Errorf("System message was validated: '%s' (%+v)", msg, err)
+    }
+}
+
+// TestValidationJSON check whether the text of JSON commands is validated
+// as well as the commands themselves.
+func TestValidationJSON(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.Controller = JSONEncoder {}
+    conf.Validators = []Validator {
+        NewControlValidator(ValidationReject),
+    }
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannel(cn)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c.ConnectUser(u1, c1)
+    recvEnvelope(t, _c1)
+
+    _c1.TestSend(`{"type": "message", "text": "hi"}`)
+    if env := recvEnvelope(t, _c1); env.Type != "message" || env.Text != "hi" {
+        t.Errorf("Valid command was refused: %+v", env)
+    }
+
+    _c1.TestSend(`{"type": "message", "text": "\u0007"}`)
+    if env := recvEnvelope(t, _c1); env.Type != "whisper" || !strings.Contains(env.Text, "control characters") {
+        t.Errorf("Escaped control character wasn't refused: %+v", env)
+    }
+}
+
+// TestValidationTopic check whether topics changed through commands are
+// validated, besides the commands themselves.
+func TestValidationTopic(t *testing.T) {
+    const u1 = "user1"
+    const cn = "chan"
+
+    conf := GetDefaultServerConf()
+    conf.Encoder = NewCommandRouterV2(nil)
+    conf.Validators = []Validator {
+        // Only the topic itself starts with a '!'.
+        NewRegexpValidator(regexp.MustCompile(`^!`), ValidationReject),
+    }
+    s := NewServerConf(conf)
+    defer s.Close()
+
+    s.CreateChannelWithOwner(cn, u1)
+    c, _ := s.GetChannel(cn)
+
+    c1 := NewMockConn()
+    _c1 := c1.(*mockConn)
+    c.ConnectUser(u1, c1)
+    _c1.TestRecv(time.Millisecond * 5)
+
+    _c1.TestSend("/topic !chat")
+    if msg, err := _c1.TestRecv(time.Millisecond * 5); err != nil || !strings.Contains(msg, "/topic failed") {
+        t.Errorf("Invalid topic wasn't refused: '%s' (%+v)", msg, err)
+    } else if want, got := "", c.Topic(); want != got {
+        t.Errorf("Invalid topic: expected '%s' but got '%s'", want, got)
+    }
+
+    _c1.TestSend("/topic chat!")
+    if msg, err := _c1.TestRecv(time.Millisecond * 5); err != nil || !strings.HasSuffix(msg, "chat!") {
+        t.Errorf("Valid topic wasn't changed: '%s' (%+v)", msg, err)
+    } else if want, got := "chat!", c.Topic(); want != got {
+        t.Errorf("Invalid topic: expected '%s' but got '%s'", want, got)
+    }
+}